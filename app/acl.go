@@ -0,0 +1,260 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 访问控制规则, deny 优先, allow 为空时允许全部
+ */
+type AclRule struct {
+	Pattern string   `json:"pattern,omitempty"` // action 通配符, 全局规则为空
+	Allow   []string `json:"allow,omitempty"`
+	Deny    []string `json:"deny,omitempty"`
+
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+/**
+ * 访问控制配置文件
+ * {"trusted": [...], "allow": [...], "deny": [...], "actions": [{"pattern": "xray.token.*", "allow": [...]}]}
+ * 订阅请求同样检查, 按规则匹配时 action 为 subscribe
+ */
+type AclConf struct {
+	Trusted []string   `json:"trusted,omitempty"` // 可信代理, 只有来自可信代理的请求才使用 X-Forwarded-For
+	Allow   []string   `json:"allow,omitempty"`
+	Deny    []string   `json:"deny,omitempty"`
+	Actions []*AclRule `json:"actions,omitempty"` // 按 action 族的规则, 先匹配的生效
+
+	trusted []netip.Prefix
+	global  *AclRule
+}
+
+/**
+ * API 访问控制, 按客户端 IP/CIDR 允许或拒绝
+ * 配置文件修改后自动重新加载, 加载失败时保留原配置
+ */
+type IpAcl struct {
+	File string
+
+	lock  sync.Mutex
+	conf  *AclConf
+	mtime time.Time
+	check time.Time
+}
+
+/**
+ * 解析 IP 或 CIDR
+ */
+func ParsePrefixes(items []string) ([]netip.Prefix, error) {
+	data := []netip.Prefix{}
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			pfx, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, errors.New("无效的 CIDR: " + item)
+			}
+			data = append(data, pfx.Masked())
+		} else if addr, err := netip.ParseAddr(item); err != nil {
+			return nil, errors.New("无效的 IP: " + item)
+		} else {
+			data = append(data, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		}
+	}
+	return data, nil
+}
+
+func MatchPrefixes(pfxs []netip.Prefix, addr netip.Addr) bool {
+	for _, pfx := range pfxs {
+		if pfx.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *AclRule) parse() (err error) {
+	if this.Pattern != "" {
+		if _, err := path.Match(this.Pattern, ""); err != nil {
+			return errors.New("无效的 pattern: " + this.Pattern)
+		}
+	}
+	if this.allow, err = ParsePrefixes(this.Allow); err != nil {
+		return err
+	}
+	this.deny, err = ParsePrefixes(this.Deny)
+	return err
+}
+
+func (this *AclRule) Permit(addr netip.Addr) bool {
+	if MatchPrefixes(this.deny, addr) {
+		return false
+	}
+	return len(this.allow) == 0 || MatchPrefixes(this.allow, addr)
+}
+
+/**
+ * 解析配置
+ */
+func ParseAclConf(bts []byte) (*AclConf, error) {
+	conf := &AclConf{}
+	if err := json.Unmarshal(bts, conf); err != nil {
+		return nil, err
+	}
+	var err error
+	if conf.trusted, err = ParsePrefixes(conf.Trusted); err != nil {
+		return nil, err
+	}
+	conf.global = &AclRule{Allow: conf.Allow, Deny: conf.Deny}
+	if err := conf.global.parse(); err != nil {
+		return nil, err
+	}
+	for _, rule := range conf.Actions {
+		if rule.Pattern == "" {
+			return nil, errors.New("规则缺少 pattern")
+		}
+		if err := rule.parse(); err != nil {
+			return nil, err
+		}
+	}
+	return conf, nil
+}
+
+// ----------------------------------------------------------------------------
+
+func (this *IpAcl) Enabled() bool {
+	return this.File != ""
+}
+
+/**
+ * 加载配置文件, 未修改时跳过
+ */
+func (this *IpAcl) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.load()
+}
+
+func (this *IpAcl) load() error {
+	stat, err := os.Stat(this.File)
+	if err != nil {
+		return err
+	}
+	if this.conf != nil && stat.ModTime().Equal(this.mtime) {
+		return nil
+	}
+	bts, err := os.ReadFile(this.File)
+	if err != nil {
+		return err
+	}
+	conf, err := ParseAclConf(bts)
+	if err != nil {
+		return err
+	}
+	if this.conf != nil {
+		fmt.Println("访问控制配置已重新加载")
+	}
+	this.conf, this.mtime = conf, stat.ModTime()
+	return nil
+}
+
+/**
+ * 当前配置, 最多每 5 秒检查一次文件是否修改
+ */
+func (this *IpAcl) Conf() *AclConf {
+	if !this.Enabled() {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if now := time.Now(); now.Sub(this.check) >= 5*time.Second {
+		this.check = now
+		if err := this.load(); err != nil {
+			fmt.Printf("加载访问控制配置失败: %s\n", err.Error())
+		}
+	}
+	return this.conf
+}
+
+/**
+ * 客户端 IP, 来自可信代理的请求使用 X-Forwarded-For 中最右侧的不可信地址
+ * unix socket 连接返回 unix
+ */
+func (this *IpAcl) ClientIP(rr *http.Request) string {
+	ip := ClientIP(rr)
+	conf := this.Conf()
+	if conf == nil || len(conf.trusted) == 0 {
+		return ip
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !MatchPrefixes(conf.trusted, addr.Unmap()) {
+		return ip
+	}
+	hops := []string{}
+	for _, val := range rr.Header.Values("X-Forwarded-For") {
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				hops = append(hops, item)
+			}
+		}
+	}
+	for idx := len(hops) - 1; idx >= 0; idx-- {
+		hop, err := netip.ParseAddr(hops[idx])
+		if err != nil {
+			// 无效的地址, 不再信任更左侧的内容
+			return ip
+		}
+		ip = hop.Unmap().String()
+		if !MatchPrefixes(conf.trusted, hop.Unmap()) {
+			break
+		}
+	}
+	return ip
+}
+
+/**
+ * 检查客户端 IP 是否允许执行操作, unix socket 连接不检查
+ */
+func (this *IpAcl) Permit(ip, action string) error {
+	conf := this.Conf()
+	if conf == nil || ip == "unix" {
+		return nil
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return errors.New("无效的客户端地址: " + ip)
+	}
+	addr = addr.Unmap()
+	if !conf.global.Permit(addr) {
+		return errors.New("不允许的来源: " + ip)
+	}
+	for _, rule := range conf.Actions {
+		if ok, _ := path.Match(rule.Pattern, action); ok {
+			if !rule.Permit(addr) {
+				return errors.New("不允许的来源: " + ip + ", " + action)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+/**
+ * 当前配置, 用于查看
+ */
+func (this *IpAcl) List() *AclConf {
+	if conf := this.Conf(); conf != nil {
+		return conf
+	}
+	return &AclConf{}
+}
@@ -0,0 +1,255 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 审计记录
+ */
+type AuditEntry struct {
+	Time      string   `json:"time"`
+	Principal string   `json:"principal"`
+	Remote    string   `json:"remote"` // 客户端 IP
+	Action    string   `json:"action"`
+	Tags      []string `json:"tags,omitempty"`
+	Digest    string   `json:"digest,omitempty"` // 脱敏后请求体的 SHA-256
+	Success   bool     `json:"success"`
+	ErrCode   string   `json:"errcode,omitempty"`
+}
+
+/**
+ * 审计查询条件
+ */
+type AuditFilter struct {
+	From      time.Time
+	To        time.Time
+	Principal string // 为空不过滤
+	Action    string // path.Match 通配符, 为空不过滤
+	Tag       string // 为空不过滤
+	Limit     int    // 返回最近的记录数量
+}
+
+/**
+ * 审计日志, 记录所有变更操作, JSON-lines 追加写入
+ * 文件超过 MaxSize 后轮转为 File.1 ... File.Keep
+ */
+type AuditLog struct {
+	File    string
+	MaxSize int64 // 单个文件最大字节数
+	Keep    int   // 保留的轮转文件数量
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (this *AuditLog) Enabled() bool {
+	return this.File != ""
+}
+
+/**
+ * 是否为需要审计的操作, 生成类操作不修改状态
+ */
+func IsAuditAction(action string) bool {
+	return strings.HasPrefix(action, "xray.") && !strings.HasPrefix(action, "xray.gen.") && !IsReadAction(action)
+}
+
+/**
+ * 请求体摘要, JSON 中的敏感字段替换为 *** 后计算
+ */
+func AuditDigest(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+	var data any
+	if err := json.Unmarshal(body, &data); err == nil {
+		if bts, err := json.Marshal(RedactSecrets(data)); err == nil {
+			body = bts
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 记录请求, 返回在请求处理完成后调用的函数, body 为已读取的请求体
+ */
+func (this *AuditLog) Begin(ww http.ResponseWriter, principal *Principal, remote, action string, tags []string, body []byte) func() {
+	entry := &AuditEntry{
+		Time:      time.Now().Format(time.RFC3339),
+		Principal: principal.String(),
+		Remote:    remote,
+		Action:    action,
+		Tags:      tags,
+		Digest:    AuditDigest(body),
+	}
+	return func() {
+		if rw, ok := ww.(*ResultWriter); ok && rw.Result != nil {
+			entry.Success, entry.ErrCode = rw.Result.Success, rw.Result.ErrCode
+		}
+		if err := this.Write(entry); err != nil {
+			fmt.Printf("写入审计日志失败: %s\n", err.Error())
+		}
+	}
+}
+
+/**
+ * 写入记录
+ */
+func (this *AuditLog) Write(entry *AuditEntry) error {
+	bts, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	bts = append(bts, '\n')
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.file != nil && this.MaxSize > 0 && this.size+int64(len(bts)) > this.MaxSize {
+		this.file.Close()
+		this.file = nil
+		this.rotate()
+	}
+	if this.file == nil {
+		file, err := os.OpenFile(this.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		this.file, this.size = file, stat.Size()
+	}
+	n, err := this.file.Write(bts)
+	this.size += int64(n)
+	return err
+}
+
+/**
+ * 轮转文件, File -> File.1 -> ... -> File.Keep
+ */
+func (this *AuditLog) rotate() {
+	if this.Keep <= 0 {
+		os.Remove(this.File)
+		return
+	}
+	os.Remove(fmt.Sprintf("%s.%d", this.File, this.Keep))
+	for idx := this.Keep - 1; idx > 0; idx-- {
+		os.Rename(fmt.Sprintf("%s.%d", this.File, idx), fmt.Sprintf("%s.%d", this.File, idx+1))
+	}
+	os.Rename(this.File, this.File+".1")
+}
+
+func (this *AuditLog) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+}
+
+/**
+ * 查询记录, 按时间顺序返回最近的 Limit 条
+ */
+func (this *AuditLog) Query(filter *AuditFilter) ([]*AuditEntry, error) {
+	if filter.Action != "" {
+		if _, err := path.Match(filter.Action, ""); err != nil {
+			return nil, err
+		}
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	files, err := this.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	data := []*AuditEntry{}
+	for _, file := range files {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			entry := &AuditEntry{}
+			if json.Unmarshal(scanner.Bytes(), entry) != nil || !filter.Match(entry) {
+				continue
+			}
+			data = append(data, entry)
+			if len(data) > filter.Limit {
+				data = data[1:]
+			}
+		}
+	}
+	return data, nil
+}
+
+/**
+ * 打开全部日志文件, 只在打开时持有锁, 打开后轮转不影响读取
+ */
+func (this *AuditLog) snapshot() ([]*os.File, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	files := []*os.File{}
+	names := []string{}
+	for idx := this.Keep; idx > 0; idx-- {
+		names = append(names, fmt.Sprintf("%s.%d", this.File, idx))
+	}
+	names = append(names, this.File)
+	for _, name := range names {
+		file, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+func (this *AuditFilter) Match(entry *AuditEntry) bool {
+	tm, err := time.Parse(time.RFC3339, entry.Time)
+	if err != nil || tm.Before(this.From) || tm.After(this.To) {
+		return false
+	}
+	if this.Principal != "" && entry.Principal != this.Principal {
+		return false
+	}
+	if this.Action != "" {
+		if ok, _ := path.Match(this.Action, entry.Action); !ok {
+			return false
+		}
+	}
+	if this.Tag != "" {
+		for _, tag := range entry.Tags {
+			if tag == this.Tag {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
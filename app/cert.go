@@ -0,0 +1,453 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+/**
+ * 证书信息
+ */
+type CertInfo struct {
+	Name      string   `json:"name"`
+	Subject   string   `json:"subject"`
+	Issuer    string   `json:"issuer"`
+	DnsNames  []string `json:"dnsNames,omitempty"`
+	NotBefore string   `json:"notBefore"`
+	NotAfter  string   `json:"notAfter"`
+	Days      int      `json:"days"`               // 剩余天数
+	Inbounds  []string `json:"inbounds,omitempty"` // 使用证书的 inbound
+}
+
+/**
+ * 证书库, 证书保存为 <Dir>/<name>.crt 和 <name>.key
+ * inbound 中使用 "certificateFile": "cert:<name>" 引用, 添加时替换为文件路径
+ */
+type CertStore struct {
+	Dir  string        // 证书目录
+	Warn time.Duration // 到期前提醒
+
+	lock   sync.Mutex
+	puts   sync.Mutex                          // 串行替换证书
+	warned map[string]time.Time                // 上次提醒时间
+	added  map[string]conf.InboundDetourConfig // 通过 API 添加且引用证书的 inbound, 不在配置中
+	staged map[string][2][]byte                // 替换中的证书 name -> {crt, key}, 写入文件前用于创建 inbound
+	stop   chan struct{}
+}
+
+var certName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+const CertPrefix = "cert:"
+
+func (this *CertStore) paths(name string) (string, string) {
+	return filepath.Join(this.Dir, name+".crt"), filepath.Join(this.Dir, name+".key")
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 替换 inbound 中的证书引用为文件路径, 返回是否使用证书库
+ * 证书配置复制后修改, 不影响共享同一 streamSettings 的其他配置
+ * 替换中的证书使用待写入的内容
+ */
+func (this *CertStore) Resolve(cinb *conf.InboundDetourConfig) (bool, error) {
+	if cinb.StreamSetting == nil || cinb.StreamSetting.TLSSettings == nil {
+		return false, nil
+	}
+	stream, tlss := *cinb.StreamSetting, *cinb.StreamSetting.TLSSettings
+	tlss.Certs = make([]*conf.TLSCertConfig, len(tlss.Certs))
+	for idx, cert := range cinb.StreamSetting.TLSSettings.Certs {
+		copied := *cert
+		tlss.Certs[idx] = &copied
+	}
+	stream.TLSSettings = &tlss
+	cinb.StreamSetting = &stream
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	found := false
+	for _, cert := range tlss.Certs {
+		name := ""
+		if this.Dir != "" && strings.HasPrefix(cert.CertFile, filepath.Join(this.Dir, "")+string(filepath.Separator)) {
+			name = strings.TrimSuffix(filepath.Base(cert.CertFile), ".crt")
+		} else if strings.HasPrefix(cert.CertFile, CertPrefix) {
+			name = strings.TrimPrefix(cert.CertFile, CertPrefix)
+			crt, key := this.paths(name)
+			if !certName.MatchString(name) || this.Dir == "" {
+				return false, errors.New("无效的证书: " + name)
+			} else if _, ok := this.staged[name]; ok {
+				// 替换中, 文件尚未写入
+			} else if _, err := os.Stat(crt); err != nil {
+				return false, errors.New("证书未找到: " + name)
+			}
+			cert.CertFile, cert.CertStr = crt, nil
+			if cert.KeyFile == "" || strings.HasPrefix(cert.KeyFile, CertPrefix) {
+				cert.KeyFile, cert.KeyStr = key, nil
+			}
+		} else {
+			continue
+		}
+		found = true
+		crt, key := this.paths(name)
+		if pems, ok := this.staged[name]; ok && cert.CertFile == crt {
+			cert.CertFile, cert.CertStr = "", strings.Split(string(pems[0]), "\n")
+			if cert.KeyFile == key {
+				cert.KeyFile, cert.KeyStr = "", strings.Split(string(pems[1]), "\n")
+			}
+		}
+	}
+	return found, nil
+}
+
+/**
+ * 记录通过 API 添加的 inbound, cinb 为空时删除记录
+ */
+func (this *CertStore) Track(tag string, cinb *conf.InboundDetourConfig) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if cinb == nil {
+		delete(this.added, tag)
+		return
+	}
+	if this.added == nil {
+		this.added = map[string]conf.InboundDetourConfig{}
+	}
+	this.added[tag] = *cinb
+}
+
+/**
+ * 获取通过 API 添加的 inbound
+ */
+func (this *CertStore) Tracked(tag string) (conf.InboundDetourConfig, bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	cinb, ok := this.added[tag]
+	return cinb, ok
+}
+
+/**
+ * 使用证书的 inbound
+ */
+func (this *CertStore) Inbounds(serve *XrayServe, name string) []string {
+	tags := []string{}
+	crt, _ := this.paths(name)
+	cinbs := serve.CopyInbounds()
+	this.lock.Lock()
+	for _, cinb := range this.added {
+		cinbs = append(cinbs, cinb)
+	}
+	this.lock.Unlock()
+	for _, cinb := range cinbs {
+		if cinb.StreamSetting == nil || cinb.StreamSetting.TLSSettings == nil {
+			continue
+		}
+		for _, cert := range cinb.StreamSetting.TLSSettings.Certs {
+			if cert.CertFile == crt {
+				tags = append(tags, cinb.Tag)
+				break
+			}
+		}
+	}
+	return tags
+}
+
+/**
+ * 保存证书, 替换已有证书时重建使用该证书的 inbound
+ * 先使用新证书创建全部 inbound, 成功后写入文件并替换, 替换失败时恢复原证书文件和 inbound
+ */
+func (this *CertStore) Put(serve *XrayServe, events *Events, name string, crtPem, keyPem []byte) (*CertInfo, error) {
+	if this.Dir == "" {
+		return nil, errors.New("未配置证书目录")
+	} else if !certName.MatchString(name) {
+		return nil, errors.New("无效的证书名称: " + name)
+	}
+	if _, err := tls.X509KeyPair(crtPem, keyPem); err != nil {
+		return nil, errors.New("无效的证书: " + err.Error())
+	}
+	this.puts.Lock()
+	defer this.puts.Unlock()
+	tags := this.Inbounds(serve, name)
+	if len(tags) == 0 {
+		if _, err := this.write(name, crtPem, keyPem); err != nil {
+			return nil, err
+		}
+		return this.info(serve, name)
+	}
+	// 重建 inbound, 加载新证书
+	this.stage(name, [2][]byte{crtPem, keyPem})
+	var restore func()
+	err := serve.RebuildInbounds(tags, func() (err error) {
+		restore, err = this.write(name, crtPem, keyPem)
+		return err
+	})
+	this.stage(name, [2][]byte{})
+	if err != nil {
+		if restore != nil {
+			restore()
+		}
+		events.Emit("cert.error", fmt.Sprintf("证书替换失败, 已恢复原证书: %s, %s", name, err.Error()), tags)
+		return nil, err
+	}
+	events.Emit("cert.renew", fmt.Sprintf("证书已替换: %s", name), tags)
+	return this.info(serve, name)
+}
+
+/**
+ * 记录替换中的证书, 内容为空时删除
+ */
+func (this *CertStore) stage(name string, pems [2][]byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if pems[0] == nil {
+		delete(this.staged, name)
+		return
+	}
+	if this.staged == nil {
+		this.staged = map[string][2][]byte{}
+	}
+	this.staged[name] = pems
+}
+
+/**
+ * 写入证书文件, 返回恢复原文件的函数, 原文件不存在时恢复为删除
+ */
+func (this *CertStore) write(name string, crtPem, keyPem []byte) (func(), error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if err := os.MkdirAll(this.Dir, 0700); err != nil {
+		return nil, err
+	}
+	crt, key := this.paths(name)
+	oldCrt, crtErr := os.ReadFile(crt)
+	oldKey, keyErr := os.ReadFile(key)
+	replace := func(file string, data []byte, perm os.FileMode) error {
+		if err := writeSync(file+".tmp", data, perm); err != nil {
+			os.Remove(file + ".tmp")
+			return err
+		}
+		return os.Rename(file+".tmp", file)
+	}
+	undo := func() {
+		for _, item := range []struct {
+			file string
+			data []byte
+			err  error
+			perm os.FileMode
+		}{{key, oldKey, keyErr, 0600}, {crt, oldCrt, crtErr, 0644}} {
+			var err error
+			if item.err == nil {
+				err = replace(item.file, item.data, item.perm)
+			} else {
+				err = os.Remove(item.file)
+			}
+			if err != nil && !os.IsNotExist(err) {
+				fmt.Printf("恢复证书文件失败: %s, %s\n", item.file, err.Error())
+			}
+		}
+	}
+	if err := replace(key, keyPem, 0600); err != nil {
+		return nil, err
+	} else if err := replace(crt, crtPem, 0644); err != nil {
+		undo()
+		return nil, err
+	}
+	delete(this.warned, name)
+	return func() {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		undo()
+	}, nil
+}
+
+/**
+ * 生成自签名证书, ECDSA P-256
+ */
+func (this *CertStore) Generate(serve *XrayServe, events *Events, name string, hosts []string, days int) (*CertInfo, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("无效的域名")
+	}
+	if days <= 0 {
+		days = 365
+	}
+	pkey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Duration(days) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pkey.PublicKey, pkey)
+	if err != nil {
+		return nil, err
+	}
+	kder, err := x509.MarshalECPrivateKey(pkey)
+	if err != nil {
+		return nil, err
+	}
+	crtPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	return this.Put(serve, events, name, crtPem, keyPem)
+}
+
+/**
+ * 删除证书, 使用中的证书不能删除
+ */
+func (this *CertStore) Del(serve *XrayServe, name string) error {
+	if !certName.MatchString(name) {
+		return errors.New("无效的证书名称: " + name)
+	}
+	if tags := this.Inbounds(serve, name); len(tags) > 0 {
+		return errors.New("证书使用中: " + strings.Join(tags, ","))
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	crt, key := this.paths(name)
+	if err := os.Remove(crt); err != nil {
+		if os.IsNotExist(err) {
+			return errors.New("证书未找到: " + name)
+		}
+		return err
+	}
+	os.Remove(key)
+	return nil
+}
+
+/**
+ * 列出证书
+ */
+func (this *CertStore) List(serve *XrayServe) ([]*CertInfo, error) {
+	data := []*CertInfo{}
+	if this.Dir == "" {
+		return data, nil
+	}
+	files, err := filepath.Glob(filepath.Join(this.Dir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".crt")
+		if info, err := this.info(serve, name); err == nil {
+			data = append(data, info)
+		} else {
+			fmt.Printf("读取证书失败: %s, %s\n", name, err.Error())
+		}
+	}
+	return data, nil
+}
+
+func (this *CertStore) info(serve *XrayServe, name string) (*CertInfo, error) {
+	crt, _ := this.paths(name)
+	bts, err := os.ReadFile(crt)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(bts)
+	if block == nil {
+		return nil, errors.New("无效的 PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CertInfo{
+		Name:      name,
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		DnsNames:  cert.DNSNames,
+		NotBefore: cert.NotBefore.Format(time.RFC3339),
+		NotAfter:  cert.NotAfter.Format(time.RFC3339),
+		Days:      int(time.Until(cert.NotAfter).Hours() / 24),
+		Inbounds:  this.Inbounds(serve, name),
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 启动到期检查, 每小时检查, 每个证书每天最多提醒一次
+ */
+func (this *CertStore) Start(serve *XrayServe, events *Events) {
+	if this.Dir == "" || this.Warn <= 0 {
+		return
+	}
+	this.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		this.Check(serve, events, time.Now())
+		for {
+			select {
+			case <-this.stop:
+				return
+			case now := <-ticker.C:
+				this.Check(serve, events, now)
+			}
+		}
+	}()
+}
+
+func (this *CertStore) Close() {
+	if this.stop == nil {
+		return
+	}
+	close(this.stop)
+	this.stop = nil
+}
+
+func (this *CertStore) Check(serve *XrayServe, events *Events, now time.Time) {
+	certs, err := this.List(serve)
+	if err != nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.warned == nil {
+		this.warned = map[string]time.Time{}
+	}
+	for _, cert := range certs {
+		after, _ := time.Parse(time.RFC3339, cert.NotAfter)
+		if after.Sub(now) > this.Warn || now.Sub(this.warned[cert.Name]) < 24*time.Hour {
+			continue
+		}
+		this.warned[cert.Name] = now
+		msg := fmt.Sprintf("证书即将到期: %s, 剩余 %d 天", cert.Name, cert.Days)
+		fmt.Println(msg)
+		events.Emit("cert.expiring", msg, cert)
+	}
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func tlsInbound(t *testing.T, tag, cert string) conf.InboundDetourConfig {
+	t.Helper()
+	cinb := conf.InboundDetourConfig{}
+	text := fmt.Sprintf(`{"tag": %q, "listen": "127.0.0.1", "port": %d, "protocol": "vless",
+		"settings": {"clients": [{"id": %q}], "decryption": "none"},
+		"streamSettings": {"security": "tls", "tlsSettings": {"certificates": [{"certificateFile": "cert:%s"}]}}}`, tag, freePort(t), testUuid, cert)
+	if err := json.Unmarshal([]byte(text), &cinb); err != nil {
+		t.Fatal(err)
+	}
+	return cinb
+}
+
+func TestCertPut(t *testing.T) {
+	serve := testXray(t)
+	certs := &CertStore{Dir: t.TempDir()}
+	serve.Certs = certs
+	events := &Events{}
+	if _, err := certs.Generate(serve, events, "a", []string{"a.example.com"}, 30); err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.AddInbound(tlsInbound(t, "tls1", "a"), true); err != nil {
+		t.Fatal(err)
+	}
+	// 配置中保存证书路径, 不是证书内容
+	if cert := serve.Xconf.InboundConfigs[0].StreamSetting.TLSSettings.Certs[0]; cert.CertFile == "" || cert.CertStr != nil {
+		t.Fatalf("config cert: %+v", cert)
+	}
+	mng := serve.XrayA.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	handler := func(tag string) inbound.Handler {
+		hdl, _ := mng.GetHandler(context.TODO(), tag)
+		return hdl
+	}
+	crt, _ := certs.paths("a")
+	read := func() []byte {
+		bts, err := os.ReadFile(crt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bts
+	}
+
+	old, data := handler("tls1"), read()
+	if _, err := certs.Generate(serve, events, "a", []string{"b.example.com"}, 30); err != nil {
+		t.Fatal(err)
+	}
+	if handler("tls1") == old || bytes.Equal(read(), data) {
+		t.Fatal("certificate not replaced")
+	}
+	if cert := serve.Xconf.InboundConfigs[0].StreamSetting.TLSSettings.Certs[0]; cert.CertFile != crt || cert.CertStr != nil {
+		t.Fatalf("config cert changed: %+v", cert)
+	}
+
+	// 第二个 inbound 不在运行中, 替换失败时证书文件和第一个 inbound 不变
+	if err := serve.AddInbound(tlsInbound(t, "tls2", "a"), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.DelInbound0("tls2"); err != nil {
+		t.Fatal(err)
+	}
+	old, data = handler("tls1"), read()
+	if _, err := certs.Generate(serve, events, "a", []string{"c.example.com"}, 30); err == nil {
+		t.Fatal("replacement succeeded with a missing inbound")
+	}
+	if handler("tls1") != old || !bytes.Equal(read(), data) {
+		t.Fatal("failed replacement changed the running inbound or the files")
+	}
+	if len(events.List("cert.error")) != 1 {
+		t.Fatal("cert.error not emitted")
+	}
+}
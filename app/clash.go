@@ -0,0 +1,156 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+/**
+ * 转换为 Clash/Mihomo 代理
+ */
+func ClashProxy(info *ShareInfo) (yaml.MapSlice, error) {
+	proxy := yaml.MapSlice{
+		{Key: "name", Value: info.Remark},
+		{Key: "server", Value: info.Address},
+		{Key: "port", Value: info.Port},
+	}
+	add := func(key string, val any) {
+		proxy = append(proxy, yaml.MapItem{Key: key, Value: val})
+	}
+	switch info.Protocol {
+	case "vless":
+		add("type", "vless")
+		add("uuid", info.Id)
+		if info.Flow != "" {
+			add("flow", info.Flow)
+		}
+	case "vmess":
+		add("type", "vmess")
+		add("uuid", info.Id)
+		add("alterId", 0)
+		add("cipher", "auto")
+	case "trojan":
+		add("type", "trojan")
+		add("password", info.Password)
+	case "shadowsocks":
+		if info.Network != "tcp" || info.Security != "none" {
+			return nil, errors.New("shadowsocks 不支持传输层配置")
+		}
+		add("type", "ss")
+		add("cipher", info.Method)
+		add("password", info.Password)
+		add("udp", true)
+		return proxy, nil
+	default:
+		return nil, errors.New("不支持的协议: " + info.Protocol)
+	}
+	add("udp", true)
+	// 传输
+	switch info.Network {
+	case "tcp":
+		if info.HeaderType != "" && info.HeaderType != "none" {
+			return nil, errors.New("不支持 tcp 伪装: " + info.HeaderType)
+		}
+	case "ws", "httpupgrade":
+		add("network", "ws")
+		opts := yaml.MapSlice{{Key: "path", Value: info.Path}}
+		if info.Host != "" {
+			opts = append(opts, yaml.MapItem{Key: "headers", Value: yaml.MapSlice{{Key: "Host", Value: info.Host}}})
+		}
+		if info.Network == "httpupgrade" {
+			opts = append(opts, yaml.MapItem{Key: "v2ray-http-upgrade", Value: true})
+		}
+		add("ws-opts", opts)
+	case "grpc":
+		add("network", "grpc")
+		add("grpc-opts", yaml.MapSlice{{Key: "grpc-service-name", Value: info.ServiceName}})
+	case "xhttp":
+		if info.Protocol != "vless" {
+			return nil, errors.New("xhttp 只支持 vless")
+		}
+		add("network", "xhttp")
+		opts := yaml.MapSlice{{Key: "path", Value: info.Path}}
+		if info.Host != "" {
+			opts = append(opts, yaml.MapItem{Key: "host", Value: info.Host})
+		}
+		if info.Mode != "" {
+			opts = append(opts, yaml.MapItem{Key: "mode", Value: info.Mode})
+		}
+		add("xhttp-opts", opts)
+	default:
+		return nil, errors.New("不支持的传输: " + info.Network)
+	}
+	// 安全
+	switch info.Security {
+	case "none":
+		if info.Protocol == "trojan" {
+			return nil, errors.New("trojan 需要 tls")
+		}
+	case "tls", "reality":
+		if info.Protocol != "trojan" {
+			add("tls", true)
+		}
+		if info.Sni != "" {
+			if info.Protocol == "trojan" {
+				add("sni", info.Sni)
+			} else {
+				add("servername", info.Sni)
+			}
+		}
+		if len(info.Alpn) > 0 {
+			add("alpn", info.Alpn)
+		}
+		if info.Fingerprint != "" {
+			add("client-fingerprint", info.Fingerprint)
+		}
+		if info.Security == "reality" {
+			opts := yaml.MapSlice{{Key: "public-key", Value: info.PublicKey}}
+			if info.ShortId != "" {
+				opts = append(opts, yaml.MapItem{Key: "short-id", Value: info.ShortId})
+			}
+			add("reality-opts", opts)
+		}
+	}
+	return proxy, nil
+}
+
+/**
+ * 生成 Clash/Mihomo 配置, 返回无法转换的条目
+ */
+func ClashProfile(infos []*ShareInfo) ([]byte, []string, error) {
+	proxies, names, errs := []yaml.MapSlice{}, []string{}, []string{}
+	for _, info := range infos {
+		proxy, err := ClashProxy(info)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", info.Remark, err.Error()))
+			continue
+		}
+		proxies = append(proxies, proxy)
+		names = append(names, info.Remark)
+	}
+	profile := yaml.MapSlice{
+		{Key: "mixed-port", Value: 7890},
+		{Key: "allow-lan", Value: false},
+		{Key: "mode", Value: "rule"},
+		{Key: "log-level", Value: "info"},
+		{Key: "proxies", Value: proxies},
+		{Key: "proxy-groups", Value: []yaml.MapSlice{{
+			{Key: "name", Value: "PROXY"},
+			{Key: "type", Value: "select"},
+			{Key: "proxies", Value: append(names, "DIRECT")},
+		}}},
+		{Key: "rules", Value: []string{
+			"DOMAIN-SUFFIX,local,DIRECT",
+			"IP-CIDR,127.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,10.0.0.0/8,DIRECT,no-resolve",
+			"IP-CIDR,172.16.0.0/12,DIRECT,no-resolve",
+			"IP-CIDR,192.168.0.0/16,DIRECT,no-resolve",
+			"GEOIP,CN,DIRECT",
+			"MATCH,PROXY",
+		}},
+	}
+	bts, err := yaml.Marshal(profile)
+	return bts, errs, err
+}
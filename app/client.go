@@ -0,0 +1,87 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+/**
+ * 客户端本地入站
+ */
+type ClientOption struct {
+	Listen    string // 监听地址
+	SocksPort uint32 // socks 端口, 0 不启用
+	HttpPort  uint32 // http 端口, 0 不启用
+}
+
+/**
+ * 生成客户端完整配置
+ * 本地 socks/http 入站, proxy 出站由分享信息生成, direct/block 出站
+ * 路由: 局域网直连, 广告屏蔽, 其余走代理
+ * conf.Config 序列化会输出全部零值字段, 所以返回 JSON 结构, 并使用 conf 类型校验
+ */
+func ClientConfig(info *ShareInfo, opt *ClientOption) (map[string]any, error) {
+	if opt.SocksPort == 0 && opt.HttpPort == 0 {
+		return nil, errors.New("至少需要一个本地入站")
+	}
+	// 入站
+	inbounds := []map[string]any{}
+	sniffing := map[string]any{"enabled": true, "destOverride": []string{"http", "tls", "quic"}, "routeOnly": true}
+	if opt.SocksPort > 0 {
+		inbounds = append(inbounds, map[string]any{
+			"tag": "socks", "protocol": "socks", "listen": opt.Listen, "port": opt.SocksPort,
+			"settings": map[string]any{"auth": "noauth", "udp": true}, "sniffing": sniffing,
+		})
+	}
+	if opt.HttpPort > 0 {
+		inbounds = append(inbounds, map[string]any{
+			"tag": "http", "protocol": "http", "listen": opt.Listen, "port": opt.HttpPort,
+			"settings": map[string]any{}, "sniffing": sniffing,
+		})
+	}
+	// 出站, 第一个为默认出站
+	proxy, err := info.OutboundJSON("proxy")
+	if err != nil {
+		return nil, err
+	}
+	outbounds := []map[string]any{
+		proxy,
+		{"tag": "direct", "protocol": "freedom"},
+		{"tag": "block", "protocol": "blackhole"},
+	}
+	// 路由
+	rules := []map[string]any{
+		{"type": "field", "ip": []string{"geoip:private"}, "outboundTag": "direct"},
+		{"type": "field", "domain": []string{"geosite:private"}, "outboundTag": "direct"},
+		{"type": "field", "domain": []string{"geosite:category-ads-all"}, "outboundTag": "block"},
+		{"type": "field", "network": "tcp,udp", "outboundTag": "proxy"},
+	}
+	xcc := map[string]any{
+		"log":       map[string]any{"loglevel": "warning"},
+		"inbounds":  inbounds,
+		"outbounds": outbounds,
+		"routing":   map[string]any{"domainStrategy": "IPIfNonMatch", "rules": rules},
+	}
+	// 校验, 路由依赖 geo 数据文件, 不在服务端构建
+	bts, err := json.Marshal(xcc)
+	if err != nil {
+		return nil, err
+	}
+	ccc := conf.Config{}
+	if err := json.Unmarshal(bts, &ccc); err != nil {
+		return nil, err
+	}
+	for _, cinb := range ccc.InboundConfigs {
+		if _, err := cinb.Build(); err != nil {
+			return nil, err
+		}
+	}
+	for _, cotb := range ccc.OutboundConfigs {
+		if _, err := cotb.Build(); err != nil {
+			return nil, err
+		}
+	}
+	return xcc, nil
+}
@@ -0,0 +1,212 @@
+package app
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+/**
+ * 加密文件头, 格式: magic + keyid 长度(1字节) + keyid + nonce(12字节) + 密文
+ * 使用 AES-256-GCM, 文件头作为附加数据
+ */
+const CryptMagic = "XWENC1"
+
+/**
+ * 配置文件加密, 密钥为 32 字节, base64 或 hex 编码
+ * keyid 为密钥 SHA-256 的前 8 位 hex, 旧密钥只用于解密
+ */
+type ConfCipher struct {
+	Current string            // 加密使用的 keyid
+	Keys    map[string][]byte // keyid -> 密钥
+}
+
+/**
+ * 解析密钥, 支持 base64 (std/url, 有无填充) 和 hex
+ */
+func ParseConfKey(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := DecodeBase64(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("无效的密钥, 需要 32 字节的 base64 或 hex")
+}
+
+/**
+ * 读取密钥, 优先使用文件, 其次使用环境变量, 都没有返回 nil
+ */
+func LoadConfKey(file, env string) ([]byte, error) {
+	if file != "" {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		return ParseConfKey(string(bts))
+	}
+	if val := os.Getenv(env); val != "" {
+		return ParseConfKey(val)
+	}
+	return nil, nil
+}
+
+func ConfKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+/**
+ * 创建加密对象, key 为当前密钥, olds 为只用于解密的旧密钥
+ */
+func NewConfCipher(key []byte, olds ...[]byte) *ConfCipher {
+	this := &ConfCipher{Current: ConfKeyId(key), Keys: map[string][]byte{}}
+	for _, old := range olds {
+		if old != nil {
+			this.Keys[ConfKeyId(old)] = old
+		}
+	}
+	this.Keys[this.Current] = key
+	return this
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(CryptMagic))
+}
+
+/**
+ * 加密, this 为 nil 时不加密
+ */
+func (this *ConfCipher) Seal(plain []byte) ([]byte, error) {
+	if this == nil {
+		return plain, nil
+	}
+	aead, err := confAead(this.Keys[this.Current])
+	if err != nil {
+		return nil, err
+	}
+	head := append([]byte(CryptMagic), byte(len(this.Current)))
+	head = append(head, this.Current...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	data := append(head, nonce...)
+	return aead.Seal(data, nonce, plain, head), nil
+}
+
+/**
+ * 解密, 未加密的内容原样返回
+ */
+func (this *ConfCipher) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if this == nil {
+		return nil, errors.New("配置文件已加密, 需要密钥(-conf-key 或环境变量 XRAYW_CONF_KEY)")
+	}
+	kid, rest, ok := cryptHead(data)
+	if !ok {
+		return nil, errors.New("无效的加密文件头")
+	}
+	key, ok := this.Keys[kid]
+	if !ok {
+		return nil, errors.New("未知的密钥: " + kid)
+	}
+	aead, err := confAead(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("无效的加密内容")
+	}
+	head := data[:len(data)-len(rest)]
+	plain, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], head)
+	if err != nil {
+		return nil, errors.New("解密失败, 密钥错误或文件已损坏")
+	}
+	return plain, nil
+}
+
+func cryptHead(data []byte) (string, []byte, bool) {
+	data = data[len(CryptMagic):]
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, false
+	}
+	return string(data[1 : 1+data[0]]), data[1+data[0]:], true
+}
+
+func confAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/**
+ * 轮换密钥, 使用当前密钥重新加密文件, 未加密的文件同时加密
+ * 先写入临时文件再替换, 避免中途失败损坏配置
+ */
+func (this *ConfCipher) Rotate(files ...string) error {
+	for _, file := range files {
+		bts, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		kid := "明文"
+		if IsEncrypted(bts) {
+			kid, _, _ = cryptHead(bts)
+		}
+		plain, err := this.Open(bts)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err.Error())
+		}
+		data, err := this.Seal(plain)
+		if err != nil {
+			return fmt.Errorf("%s: %s", file, err.Error())
+		}
+		stat, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if err := writeSync(file+".tmp", data, stat.Mode().Perm()); err != nil {
+			os.Remove(file + ".tmp")
+			return err
+		}
+		if err := os.Rename(file+".tmp", file); err != nil {
+			return err
+		}
+		fmt.Printf("配置文件: %s, 密钥: %s -> %s\n", file, kid, this.Current)
+	}
+	return nil
+}
+
+/**
+ * 写入文件并同步到磁盘, 替换前确保内容完整
+ */
+func writeSync(file string, data []byte, perm os.FileMode) error {
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
@@ -0,0 +1,147 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestConfCipherRoundTrip(t *testing.T) {
+	crypt := NewConfCipher(testKey(t))
+	plain := []byte(`{"inbounds": []}`)
+	data, err := crypt.Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(data) || bytes.Contains(data, plain) {
+		t.Fatal("sealed data is not encrypted")
+	}
+	out, err := crypt.Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, plain) {
+		t.Fatalf("got %q", out)
+	}
+	// 未加密的内容原样返回
+	if out, err := crypt.Open(plain); err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("plain: %q, %v", out, err)
+	}
+	// 没有密钥时不加密, 无法解密
+	if out, err := (*ConfCipher)(nil).Seal(plain); err != nil || !bytes.Equal(out, plain) {
+		t.Fatalf("nil seal: %q, %v", out, err)
+	}
+	if _, err := (*ConfCipher)(nil).Open(data); err == nil {
+		t.Fatal("nil cipher opened encrypted data")
+	}
+}
+
+func TestConfCipherTampered(t *testing.T) {
+	crypt := NewConfCipher(testKey(t))
+	data, err := crypt.Seal([]byte("secret config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := len(CryptMagic) + 1 + len(crypt.Current)
+	cases := map[string]func([]byte) []byte{
+		"ciphertext": func(bts []byte) []byte { bts[len(bts)-1] ^= 1; return bts },
+		"nonce":      func(bts []byte) []byte { bts[head] ^= 1; return bts },
+		"keyid length": func(bts []byte) []byte {
+			bts[len(CryptMagic)] = 0xff
+			return bts
+		},
+		"truncated": func(bts []byte) []byte { return bts[:head+4] },
+	}
+	for name, tamper := range cases {
+		t.Run(name, func(t *testing.T) {
+			bts := tamper(append([]byte{}, data...))
+			if _, err := crypt.Open(bts); err == nil {
+				t.Fatal("tampered data opened")
+			}
+		})
+	}
+	// 文件头作为附加数据, 替换 keyid 后即使密钥相同也无法解密
+	other := NewConfCipher(crypt.Keys[crypt.Current])
+	other.Current = strings.Repeat("0", len(crypt.Current))
+	other.Keys[other.Current] = crypt.Keys[crypt.Current]
+	bts := append([]byte{}, data...)
+	copy(bts[len(CryptMagic)+1:], other.Current)
+	if _, err := other.Open(bts); err == nil {
+		t.Fatal("header tampering not detected")
+	}
+}
+
+func TestConfCipherUnknownKey(t *testing.T) {
+	data, err := NewConfCipher(testKey(t)).Seal([]byte("secret config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewConfCipher(testKey(t)).Open(data)
+	if err == nil || !strings.Contains(err.Error(), "未知的密钥") {
+		t.Fatalf("want unknown key error, got %v", err)
+	}
+}
+
+func TestConfCipherRotate(t *testing.T) {
+	dir := t.TempDir()
+	old, key := testKey(t), testKey(t)
+	plain := []byte(`{"outbounds": [{"protocol": "freedom"}]}`)
+	enc, raw, missing := filepath.Join(dir, "enc.json"), filepath.Join(dir, "raw.json"), filepath.Join(dir, "none.json")
+	data, err := NewConfCipher(old).Seal(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(enc, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(raw, plain, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有旧密钥时失败, 文件不变
+	if err := NewConfCipher(key).Rotate(enc); err == nil {
+		t.Fatal("rotate without old key succeeded")
+	}
+	if bts, _ := os.ReadFile(enc); !bytes.Equal(bts, data) {
+		t.Fatal("file changed after failed rotation")
+	}
+
+	crypt := NewConfCipher(key, old)
+	if err := crypt.Rotate(enc, raw, missing); err != nil {
+		t.Fatal(err)
+	}
+	current := NewConfCipher(key)
+	for _, file := range []string{enc, raw} {
+		bts, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kid, _, ok := cryptHead(bts); !IsEncrypted(bts) || !ok || kid != current.Current {
+			t.Fatalf("%s: not sealed with current key", file)
+		}
+		if out, err := current.Open(bts); err != nil || !bytes.Equal(out, plain) {
+			t.Fatalf("%s: %q, %v", file, out, err)
+		}
+		if _, err := os.Stat(file + ".tmp"); !os.IsNotExist(err) {
+			t.Fatalf("%s: temp file left behind", file)
+		}
+	}
+	if stat, err := os.Stat(raw); err != nil || stat.Mode().Perm() != 0640 {
+		t.Fatalf("permissions not kept: %v", stat.Mode())
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Fatal("missing file created")
+	}
+}
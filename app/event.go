@@ -0,0 +1,73 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/**
+ * 事件
+ */
+type Event struct {
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Subject string `json:"subject,omitempty"` // 操作主体
+	Data    any    `json:"data,omitempty"`
+}
+
+/**
+ * 事件记录, 保留最近的事件
+ */
+type Events struct {
+	Limit int // 保留数量
+
+	lock sync.RWMutex
+	list []*Event
+}
+
+/**
+ * 发出事件
+ */
+func (this *Events) Emit(typ, msg string, data any) {
+	this.EmitBy("", typ, msg, data)
+}
+
+/**
+ * 发出事件, 记录操作主体
+ */
+func (this *Events) EmitBy(subject, typ, msg string, data any) {
+	evt := &Event{Time: time.Now().Format(time.RFC3339), Type: typ, Message: msg, Subject: subject, Data: data}
+	if subject != "" {
+		fmt.Printf("[事件] %s: %s (%s)\n", typ, msg, subject)
+	} else {
+		fmt.Printf("[事件] %s: %s\n", typ, msg)
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	limit := this.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+	this.list = append(this.list, evt)
+	if len(this.list) > limit {
+		this.list = append([]*Event{}, this.list[len(this.list)-limit:]...)
+	}
+}
+
+/**
+ * 列出事件, typ 为空列出全部
+ */
+func (this *Events) List(typ string) []*Event {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	data := []*Event{}
+	for _, evt := range this.list {
+		if typ == "" || evt.Type == typ {
+			data = append(data, evt)
+		}
+	}
+	return data
+}
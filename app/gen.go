@@ -0,0 +1,268 @@
+package app
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/xtls/xray-core/common/uuid"
+	"lukechampine.com/blake3"
+)
+
+/**
+ * x25519 密钥对, 同 xray x25519 / xray wg
+ */
+type X25519Key struct {
+	PrivateKey string `json:"privateKey"`
+	PublicKey  string `json:"publicKey"` // REALITY 客户端 password
+	Hash32     string `json:"hash32,omitempty"`
+}
+
+/**
+ * 生成 x25519 密钥对, priv 为空随机生成, std 使用标准 base64 (WireGuard)
+ */
+func GenX25519(priv string, std bool) (*X25519Key, error) {
+	encoding := base64.RawURLEncoding
+	if std {
+		encoding = base64.StdEncoding
+	}
+	key := make([]byte, 32)
+	if priv != "" {
+		bts, err := encoding.DecodeString(priv)
+		if err != nil || len(bts) != 32 {
+			return nil, errors.New("无效的私钥")
+		}
+		copy(key, bts)
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// https://cr.yp.to/ecdh.html
+	key[0] &= 248
+	key[31] &= 127
+	key[31] |= 64
+	pkey, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	pub := pkey.PublicKey().Bytes()
+	data := &X25519Key{PrivateKey: encoding.EncodeToString(key), PublicKey: encoding.EncodeToString(pub)}
+	if !std {
+		hash32 := blake3.Sum256(pub)
+		data.Hash32 = encoding.EncodeToString(hash32[:])
+	}
+	return data, nil
+}
+
+/**
+ * 生成 UUID, input 为空生成 UUIDv4, 否则生成 UUIDv5 (VLESS), 同 xray uuid
+ */
+func GenUUID(input string) (string, error) {
+	if input == "" {
+		uid := uuid.New()
+		return uid.String(), nil
+	} else if len(input) > 30 {
+		return "", errors.New("input 不能超过 30 字节")
+	}
+	uid, err := uuid.ParseString(input)
+	if err != nil {
+		return "", err
+	}
+	return uid.String(), nil
+}
+
+/**
+ * 生成 REALITY shortId, size 为字节数 (1-8)
+ */
+func GenShortId(size int) (string, error) {
+	if size < 1 || size > 8 {
+		return "", errors.New("无效的长度, 范围 1-8")
+	}
+	bts := make([]byte, size)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bts), nil
+}
+
+/**
+ * 生成 Shadowsocks 2022 密钥, 长度由加密方式决定
+ */
+func GenSS2022(method string) (string, error) {
+	size := 0
+	switch method {
+	case "2022-blake3-aes-128-gcm":
+		size = 16
+	case "", "2022-blake3-aes-256-gcm", "2022-blake3-chacha20-poly1305":
+		size = 32
+	default:
+		return "", errors.New("无效的加密方式: " + method)
+	}
+	bts := make([]byte, size)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bts), nil
+}
+
+// ----------------------------------------------------------------------------
+
+var genHolder = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9]+)(\.[a-zA-Z]+)?(#[0-9a-zA-Z]+)?\s*\}\}`)
+
+/**
+ * 填充模版中的占位符, 同名占位符使用同一个值, 用 #n 区分多个值
+ * {{uuid}}, {{shortId}}, {{x25519.privateKey}}, {{x25519.publicKey}}, {{wg.privateKey}}, {{wg.publicKey}}
+ * {{ss128}} 2022-blake3-aes-128-gcm 密钥, {{ss256}} 2022-blake3-aes-256-gcm 密钥
+ */
+func GenTemplate(tmpl []byte) ([]byte, map[string]any, error) {
+	values := map[string]any{}
+	var err error = nil
+	data := genHolder.ReplaceAllFunc(tmpl, func(src []byte) []byte {
+		match := genHolder.FindSubmatch(src)
+		kind, field, name := string(match[1]), string(match[2]), string(match[1])+string(match[3])
+		if err != nil {
+			return src
+		}
+		if values[name] == nil {
+			switch kind {
+			case "uuid":
+				values[name], err = GenUUID("")
+			case "shortId":
+				values[name], err = GenShortId(8)
+			case "x25519":
+				values[name], err = GenX25519("", false)
+			case "wg":
+				values[name], err = GenX25519("", true)
+			case "ss128":
+				values[name], err = GenSS2022("2022-blake3-aes-128-gcm")
+			case "ss256":
+				values[name], err = GenSS2022("2022-blake3-aes-256-gcm")
+			default:
+				err = errors.New("无效的占位符: " + string(src))
+			}
+			if err != nil {
+				return src
+			}
+		}
+		switch val := values[name].(type) {
+		case string:
+			if field == "" {
+				return []byte(val)
+			}
+		case *X25519Key:
+			switch field {
+			case ".privateKey":
+				return []byte(val.PrivateKey)
+			case ".publicKey":
+				return []byte(val.PublicKey)
+			}
+		}
+		err = errors.New("无效的占位符: " + string(src))
+		return src
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, values, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ *
+ * 密钥生成, 算法同 xray 命令
+ *
+ * x25519 密钥对 (REALITY), private 可选
+ * xray.gen.X25519
+ *
+ * WireGuard 密钥对, private 可选
+ * xray.gen.WireGuard
+ *
+ * UUID, input 可选, 生成 UUIDv5
+ * xray.gen.UUID
+ *
+ * REALITY shortId, size 为字节数, 默认 8, count 默认 1
+ * xray.gen.ShortId
+ *
+ * Shadowsocks 2022 密钥, method 默认 2022-blake3-aes-256-gcm
+ * xray.gen.SS2022
+ *
+ * 填充 inbound 模版中的占位符, 见 GenTemplate
+ * xray.gen.Template
+ *
+ */
+func (this *Worker) genz(ac string, ww http.ResponseWriter, rr *http.Request) {
+	var resp *Result = nil
+	query := rr.URL.Query()
+
+	switch ac {
+	case "xray.gen.X25519":
+		if data, err := GenX25519(query.Get("private"), false); err != nil {
+			resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	case "xray.gen.WireGuard":
+		if data, err := GenX25519(query.Get("private"), true); err != nil {
+			resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	case "xray.gen.UUID":
+		if data, err := GenUUID(query.Get("input")); err != nil {
+			resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	case "xray.gen.ShortId":
+		size, count := 8, 1
+		if val := query.Get("size"); val != "" {
+			size, _ = strconv.Atoi(val)
+		}
+		if val := query.Get("count"); val != "" {
+			count, _ = strconv.Atoi(val)
+		}
+		if count < 1 || count > 100 {
+			resp = &Result{ErrCode: "invalid_count", Message: "无效的 count, 范围 1-100"}
+			break
+		}
+		data := []string{}
+		for range count {
+			sid, err := GenShortId(size)
+			if err != nil {
+				resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+				break
+			}
+			data = append(data, sid)
+		}
+		if resp == nil {
+			resp = &Result{Success: true, Data: data}
+		}
+	case "xray.gen.SS2022":
+		if data, err := GenSS2022(query.Get("method")); err != nil {
+			resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	case "xray.gen.Template":
+		if body, err := io.ReadAll(rr.Body); err != nil {
+			resp = &Result{ErrCode: "invalid_body", Message: "无效的请求: " + err.Error()}
+		} else if data, values, err := GenTemplate(body); err != nil {
+			resp = &Result{ErrCode: "error_gen", Message: "错误: " + err.Error()}
+		} else if !json.Valid(data) {
+			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON 模版"}
+		} else {
+			resp = &Result{Success: true, Data: map[string]any{"inbound": json.RawMessage(data), "values": values}}
+		}
+	}
+	if resp == nil {
+		resp = &Result{ErrCode: "invalid_xray", Message: "无效的操作: " + ac}
+	}
+	Response(rr, ww, resp)
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * HMAC 签名认证, 防止令牌明文传输和重放
+ * Authorization: HMAC-SHA256 id=<令牌id>, ts=<unix秒>, nonce=<随机串>, sig=<base64签名>
+ * 签名内容: METHOD\naction\nquery\nhex(sha256(body))\nts\nnonce, query 为按 key 排序的 URL 编码
+ * 签名密钥: id=default 使用 -token, 其他使用创建令牌时返回的签名密钥(sign)
+ * 签名密钥加密保存在令牌库中, 只有令牌库文件无法伪造签名
+ */
+type NonceCache struct {
+	Window time.Duration // 允许的时间偏差
+
+	lock sync.Mutex
+	seen map[string]time.Time // nonce -> 过期时间
+}
+
+/**
+ * 记录 nonce, 已使用返回 false
+ */
+func (this *NonceCache) Use(nonce string, now time.Time) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.seen == nil {
+		this.seen = map[string]time.Time{}
+	}
+	if len(this.seen) > 1024 {
+		for key, expire := range this.seen {
+			if now.After(expire) {
+				delete(this.seen, key)
+			}
+		}
+	}
+	if expire, ok := this.seen[nonce]; ok && now.Before(expire) {
+		return false
+	}
+	// 时间戳在 ±Window 内有效, 保留 2*Window 覆盖整个有效期
+	this.seen[nonce] = now.Add(2 * this.Window)
+	return true
+}
+
+/**
+ * 计算请求签名
+ */
+func HmacSign(key []byte, rr *http.Request, body []byte, ts, nonce string) string {
+	sum := sha256.Sum256(body)
+	text := strings.Join([]string{rr.Method, RequestAction(rr), rr.URL.Query().Encode(), hex.EncodeToString(sum[:]), ts, nonce}, "\n")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(text))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * 验证 HMAC 签名
+ */
+func (this *Worker) authorizeHmac(rr *http.Request, auth string) (*Principal, *Result) {
+	params := map[string]string{}
+	for _, part := range strings.Split(auth, ",") {
+		if key, val, ok := strings.Cut(strings.TrimSpace(part), "="); ok {
+			params[key] = val
+		}
+	}
+	id, ts, nonce, sig := params["id"], params["ts"], params["nonce"], params["sig"]
+	if id == "" || ts == "" || sig == "" || len(nonce) < 8 || len(nonce) > 64 {
+		return nil, &Result{ErrCode: "invalid_token", Message: "无效的签名参数"}
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, &Result{ErrCode: "invalid_token", Message: "无效的时间戳"}
+	}
+	now := time.Now()
+	if diff := now.Sub(time.Unix(secs, 0)); diff > this.Nonces.Window || diff < -this.Nonces.Window {
+		return nil, &Result{ErrCode: "invalid_token", Message: "签名已过期"}
+	}
+	// 签名密钥
+	pp, key := (*Principal)(nil), []byte(nil)
+	if id == "default" && this.Token != "" {
+		pp, key = &Principal{Kind: "token", Id: "default", Scopes: []string{"admin"}}, []byte(this.Token)
+	} else if token, err := this.Tokens.Get(id, now); err != nil {
+		return nil, &Result{ErrCode: "invalid_token", Message: err.Error()}
+	} else if key, err = this.Tokens.SignKey(token); err != nil {
+		return nil, &Result{ErrCode: "invalid_token", Message: err.Error()}
+	} else {
+		pp = &Principal{Kind: "token", Id: token.Id, Scopes: token.Scopes}
+	}
+	body, err := ReadBody(rr)
+	if err != nil {
+		return nil, &Result{ErrCode: "invalid_body", Message: "无效的请求: " + err.Error()}
+	}
+	if !hmac.Equal([]byte(HmacSign(key, rr, body, ts, nonce)), []byte(sig)) {
+		return nil, &Result{ErrCode: "invalid_token", Message: "无效的签名"}
+	}
+	// 签名通过后再记录 nonce, 避免伪造请求占用
+	if !this.Nonces.Use(id+":"+nonce, now) {
+		return nil, &Result{ErrCode: "invalid_token", Message: "重复的请求"}
+	}
+	return pp, nil
+}
+
+/**
+ * 根据 id 获取令牌, 检查是否过期
+ */
+func (this *TokenStore) Get(id string, now time.Time) (*ApiToken, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	token, ok := this.tokens[id]
+	if !ok {
+		return nil, errors.New("无效的令牌")
+	}
+	if token.Expired(now) {
+		return nil, errors.New("令牌已过期")
+	}
+	return token, nil
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * 创建签名请求, body 为签名内容, sent 为实际发送内容
+ */
+func hmacRequest(key, body, sent string, ts int64, nonce string) (*http.Request, string) {
+	rr := httptest.NewRequest("POST", "/?action=xray.app.proxyman.conf.LstInbound&tag=in", strings.NewReader(sent))
+	sts := fmt.Sprint(ts)
+	sig := HmacSign([]byte(key), rr, []byte(body), sts, nonce)
+	return rr, fmt.Sprintf("id=default, ts=%s, nonce=%s, sig=%s", sts, nonce, sig)
+}
+
+func TestAuthorizeHmac(t *testing.T) {
+	now := time.Now().Unix()
+	cases := []struct {
+		name  string
+		key   string
+		body  string
+		sent  string
+		ts    int64
+		nonce string
+		code  string
+	}{
+		{"valid", "secret", `{"a":1}`, `{"a":1}`, now, "nonce-valid", ""},
+		{"signature mismatch", "other", `{"a":1}`, `{"a":1}`, now, "nonce-key", "invalid_token"},
+		{"body tampered", "secret", `{"a":1}`, `{"a":2}`, now, "nonce-body", "invalid_token"},
+		{"timestamp too old", "secret", "", "", now - 301, "nonce-old", "invalid_token"},
+		{"timestamp in future", "secret", "", "", now + 301, "nonce-future", "invalid_token"},
+		{"timestamp within window", "secret", "", "", now - 250, "nonce-skew", ""},
+		{"short nonce", "secret", "", "", now, "short", "invalid_token"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			worker := &Worker{Token: "secret", Nonces: NonceCache{Window: 300 * time.Second}}
+			rr, auth := hmacRequest(tc.key, tc.body, tc.sent, tc.ts, tc.nonce)
+			pp, res := worker.authorizeHmac(rr, auth)
+			if tc.code == "" {
+				if res != nil {
+					t.Fatalf("rejected: %s", res.Message)
+				}
+				if pp.Id != "default" || pp.Allow("xray.app.proxyman.conf.AddInbound", nil) != nil {
+					t.Fatalf("principal: %#v", pp)
+				}
+				// 验证后请求体仍可读取
+				if bts, _ := io.ReadAll(rr.Body); string(bts) != tc.sent {
+					t.Fatalf("body: %q", bts)
+				}
+			} else if res == nil || res.ErrCode != tc.code {
+				t.Fatalf("result: %#v", res)
+			}
+		})
+	}
+}
+
+func TestAuthorizeHmacReplay(t *testing.T) {
+	worker := &Worker{Token: "secret", Nonces: NonceCache{Window: 300 * time.Second}}
+	now := time.Now().Unix()
+	rr, auth := hmacRequest("secret", "{}", "{}", now, "nonce-replay")
+	if _, res := worker.authorizeHmac(rr, auth); res != nil {
+		t.Fatal(res.Message)
+	}
+	rr, auth = hmacRequest("secret", "{}", "{}", now, "nonce-replay")
+	if _, res := worker.authorizeHmac(rr, auth); res == nil || res.Message != "重复的请求" {
+		t.Fatalf("replay accepted: %#v", res)
+	}
+}
+
+func TestAuthorizeHmacNonceOrder(t *testing.T) {
+	worker := &Worker{Token: "secret", Nonces: NonceCache{Window: 300 * time.Second}}
+	now := time.Now().Unix()
+	// 签名错误的请求不记录 nonce, 不能占用合法请求的 nonce
+	rr, auth := hmacRequest("forged", "{}", "{}", now, "nonce-order")
+	if _, res := worker.authorizeHmac(rr, auth); res == nil || res.Message != "无效的签名" {
+		t.Fatalf("forged accepted: %#v", res)
+	}
+	rr, auth = hmacRequest("secret", "{}", "{}", now, "nonce-order")
+	if _, res := worker.authorizeHmac(rr, auth); res != nil {
+		t.Fatalf("valid rejected: %s", res.Message)
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	cache := &NonceCache{Window: time.Minute}
+	now := time.Now()
+	if !cache.Use("a", now) {
+		t.Fatal("first use rejected")
+	}
+	if cache.Use("a", now.Add(time.Minute)) {
+		t.Fatal("reused within window")
+	}
+	// 超过 2*Window 后时间戳已失效, nonce 可以释放
+	if !cache.Use("a", now.Add(2*time.Minute+time.Second)) {
+		t.Fatal("expired nonce rejected")
+	}
+}
@@ -1,10 +1,12 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,18 +25,36 @@ type HandlerFunc = func(ac string, ww http.ResponseWriter, rr *http.Request)
  * 定义处理对象
  */
 type Worker struct {
-	Token string
-	Route map[string]HandlerFunc
-	Serve XrayServe
+	Token   string
+	Route   map[string]HandlerFunc
+	Serve   XrayServe
+	Traffic TrafficStore
+	Metrics Metrics
+	Events  Events
+	IpLimit IpLimiter
+	Remote  RemoteStore
+	Certs   CertStore
+	Tokens  TokenStore
+	Nonces  NonceCache
+	Jwt     JwtVerifier
+	Tls     ApiTls
+	Unix    UnixSock
+	Limit   RateLimiter
+	Audit   AuditLog
+	Acl     IpAcl
+
+	Subscribe Subscribe
 }
 
 /**
  * 创建处理对象
  */
 func NewHandler() *Worker {
-	worker := &Worker{}
+	worker := &Worker{Subscribe: Subscribe{Path: "/sub/"}}
+	worker.Serve.Certs = &worker.Certs
 	worker.Route = map[string]HandlerFunc{
 		"healthz": worker.healthz,
+		"metrics": worker.metrics,
 	}
 	return worker
 }
@@ -64,6 +84,13 @@ type ReqBody struct {
  * 响应结果
  */
 func Response(rr *http.Request, ww http.ResponseWriter, resp *Result) {
+	if resp.Data != nil && !RequestPrincipal(rr).Reveal() {
+		// 没有 reveal-secrets 权限, 脱敏响应中的凭据
+		resp.Data = RedactValue(resp.Data)
+	}
+	if rw, ok := ww.(*ResultWriter); ok {
+		rw.Result = resp
+	}
 	ww.Header().Set("Content-Type", "application/json; charset=utf-8")
 	json.NewEncoder(ww).Encode(resp)
 }
@@ -72,17 +99,60 @@ func Response(rr *http.Request, ww http.ResponseWriter, resp *Result) {
  * HTTP处理
  */
 func (this *Worker) ServeHTTP(ww http.ResponseWriter, rr *http.Request) {
-	// 需要验证令牌
-	if this.Token != "" {
-		if token := rr.Header.Get("Authorization"); token == "Token "+this.Token {
-			// pass
-		} else {
-			resp := Result{ErrCode: "invalid_token", Message: "无效的令牌"}
-			Response(rr, ww, &resp)
-			return
-		}
+	start := time.Now()
+	rw := &ResultWriter{ResponseWriter: ww}
+	this.serveHTTP(rw, rr)
+	// 记录指标, 只有通过认证且分发到处理函数的 action 单独记录, 避免标签膨胀
+	action, errcode := rw.Action, ""
+	if this.IsSubscribe(rr) {
+		action = "subscribe"
 	}
-	// 处理 action
+	if rw.Result != nil {
+		errcode = rw.Result.ErrCode
+	}
+	switch errcode {
+	case "invalid_action", "invalid_xray":
+		action = ""
+	}
+	if action == "" {
+		action = "other"
+	}
+	this.Metrics.Observe(action, errcode, time.Since(start))
+}
+
+/**
+ * 请求体最大字节数
+ */
+const MaxBodySize = 8 << 20
+
+/**
+ * 限制请求体大小, 超过 MaxBodySize 读取时返回错误
+ */
+func LimitBody(ww http.ResponseWriter, rr *http.Request) {
+	if rr.Body != nil {
+		rr.Body = http.MaxBytesReader(ww, rr.Body, MaxBodySize)
+	}
+}
+
+/**
+ * 读取请求体并重置, 后续处理读取缓存的内容
+ */
+func ReadBody(rr *http.Request) ([]byte, error) {
+	if rr.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(rr.Body)
+	if err != nil {
+		return nil, err
+	}
+	rr.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+/**
+ * 获取请求的 action
+ */
+func RequestAction(rr *http.Request) string {
 	action := rr.URL.Query().Get("action")
 	if action == "" {
 		rpath := rr.URL.Path
@@ -91,16 +161,119 @@ func (this *Worker) ServeHTTP(ww http.ResponseWriter, rr *http.Request) {
 		}
 		action = rpath
 	}
+	return action
+}
+
+/**
+ * 判断是否为订阅请求
+ */
+func (this *Worker) IsSubscribe(rr *http.Request) bool {
+	return this.Subscribe.Key != "" && rr.URL.Query().Get("action") == "" && strings.HasPrefix(rr.URL.Path, this.Subscribe.Path)
+}
+
+func (this *Worker) serveHTTP(ww http.ResponseWriter, rr *http.Request) {
+	// 按来源 IP 访问控制, 订阅使用 action 名称 subscribe 匹配规则
+	ip, now := this.Acl.ClientIP(rr), time.Now()
+	if this.IsSubscribe(rr) {
+		if err := this.Acl.Permit(ip, "subscribe"); err != nil {
+			http.Error(ww, err.Error(), http.StatusForbidden)
+			return
+		}
+		// 订阅使用订阅 token 验证
+		this.subscribe(ww, rr)
+		return
+	}
+	if err := this.Acl.Permit(ip, RequestAction(rr)); err != nil {
+		resp := Result{ErrCode: "ip_denied", Message: err.Error()}
+		Response(rr, ww, &resp)
+		return
+	}
+	// 限制请求体大小, 只有 HMAC 签名验证在认证时读取请求体
+	LimitBody(ww, rr)
+	// 连续认证失败的客户端被锁定, unix socket 连接按对端 uid 区分
+	ckey := ClientKey(rr, ip)
+	if wait := this.Limit.Locked(ckey, now); wait > 0 {
+		resp := Result{ErrCode: "locked", Message: retryMessage("认证失败次数过多", wait)}
+		TooManyRequests(rr, ww, wait, &resp)
+		return
+	}
+	// 需要验证令牌
+	principal, resp := this.authorize(rr)
+	if resp != nil {
+		if resp.ErrCode == "invalid_token" {
+			if wait := this.Limit.Fail(ckey, now); wait > 0 {
+				this.Events.Emit("auth.lockout", ckey, map[string]any{"ip": ckey, "seconds": int(wait.Seconds())})
+			}
+		}
+		Response(rr, ww, resp)
+		return
+	}
+	if principal.Kind != "none" {
+		this.Limit.Success(ckey)
+	}
+	// 认证通过后读取请求体, tag 解析, 审计等读取缓存的请求体
+	body, err := ReadBody(rr)
+	if err != nil {
+		resp := Result{ErrCode: "invalid_body", Message: "无效的请求: " + err.Error()}
+		Response(rr, ww, &resp)
+		return
+	}
+	rr = WithPrincipal(rr, principal)
+	// 处理 action
+	action := RequestAction(rr)
 	if action == "" {
 		resp := Result{ErrCode: "empty_action", Message: "空的操作"}
 		Response(rr, ww, &resp)
 		return
 	}
-	if rr.Method != http.MethodPost && action != "healthz" {
-		// 只有 healthz 允许 GET 请求
+	// 按 action 族限流
+	pkey := ""
+	if principal.Kind != "none" {
+		pkey = principal.String()
+	}
+	if wait := this.Limit.Allow(ckey, pkey, action, now); wait > 0 {
+		resp := Result{ErrCode: "rate_limited", Message: retryMessage("请求过于频繁", wait)}
+		TooManyRequests(rr, ww, wait, &resp)
+		return
+	}
+	// 检查令牌权限
+	tags := RequestTags(rr)
+	// 记录变更操作的审计日志, 包括没有权限的请求
+	if this.Audit.Enabled() && IsAuditAction(action) {
+		defer this.Audit.Begin(ww, principal, ip, action, tags, body)()
+	}
+	if err := principal.Allow(action, tags); err != nil {
+		resp := Result{ErrCode: "forbidden", Message: "没有权限: " + err.Error()}
+		Response(rr, ww, &resp)
+		return
+	}
+	if rr.Method != http.MethodPost && action != "healthz" && action != "metrics" {
+		// 只有 healthz 和 metrics 允许 GET 请求
 		resp := Result{ErrCode: "invalid_method", Message: "无效的请求方法"}
 		Response(rr, ww, &resp)
 		return
+	}
+	rw, _ := ww.(*ResultWriter)
+	if rw != nil {
+		rw.Action = action
+	}
+	// 记录认证主体的变更操作, 处理完成后根据结果记录
+	if principal.Kind != "none" && !IsReadAction(action) {
+		defer func() {
+			data := map[string]any{"tags": tags, "success": true}
+			if rw != nil && rw.Result != nil {
+				data["success"] = rw.Result.Success
+				if rw.Result.ErrCode != "" {
+					data["errcode"] = rw.Result.ErrCode
+				}
+			}
+			this.Events.EmitBy(principal.String(), "api.action", action, data)
+		}()
+	}
+	if strings.HasPrefix(action, "xray.token.") {
+		this.tokenz(action, ww, rr)
+	} else if strings.HasPrefix(action, "xray.gen.") {
+		this.genz(action, ww, rr)
 	} else if strings.HasPrefix(action, "xray.") {
 		this.xrayz(action, ww, rr)
 	} else if handle, ok := this.Route[action]; ok {
@@ -119,6 +292,15 @@ func (this *Worker) healthz(ac string, ww http.ResponseWriter, rr *http.Request)
 	Response(rr, ww, &resp)
 }
 
+/**
+ * Prometheus 指标
+ */
+func (this *Worker) metrics(ac string, ww http.ResponseWriter, rr *http.Request) {
+	ww.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.Serve.WriteMetrics(ww)
+	this.Metrics.Write(ww)
+}
+
 // ----------------------------------------------------------------------------
 
 type IOboundCoreConfig struct {
@@ -135,7 +317,8 @@ type IOboundConfConfig struct {
  *
  * Xray 处理
  *
- * 参数同 配置 文件
+ * 参数同 配置 文件, conf 操作同步到内存配置(分享链接, 用户管理等依赖), 路由需要 ruleTag, 删除时需在配置中存在
+ * core 操作只修改运行实例
  * xray.app.proxyman.conf.AddInbound
  * xray.app.proxyman.conf.DelInbound
  * xray.app.proxyman.conf.LstInbound
@@ -171,6 +354,85 @@ type IOboundConfConfig struct {
  * xray.app.proxyman.conf.LstStats
  * xray.app.proxyman.core.LstStats
  *
+ * 保存内存配置到运行配置文件(配置文件.偏移量), 重启后使用, 配置了密钥时加密
+ * xray.app.proxyman.conf.SaveConf
+ *
+ * 策略, level 为用户等级, 修改后新的连接生效
+ * xray.app.proxyman.conf.LstPolicy
+ * xray.app.proxyman.conf.SetPolicy
+ * xray.app.proxyman.conf.DelPolicy
+ * xray.app.proxyman.conf.SetSysPolicy
+ *
+ * 用户, 需要 inbound 配置, level 可选
+ * xray.app.proxyman.conf.AddUser
+ * xray.app.proxyman.conf.DelUser
+ *
+ * 分享链接, host 为服务的公网地址
+ * xray.app.proxyman.conf.GetShareLink
+ *
+ * 分享链接二维码, format=png|svg, size 像素, level=L|M|Q|H, 成功时直接返回图片
+ * xray.app.proxyman.conf.GetQrCode
+ *
+ * 客户端完整配置, host 为服务的公网地址, socks/http 为本地端口, 0 不启用
+ * xray.app.proxyman.conf.GetClientConf
+ *
+ * 导出 Clash/Mihomo 配置, host 为服务的公网地址
+ * xray.app.proxyman.conf.GetClash
+ *
+ * 导出 sing-box 出站配置, host 为服务的公网地址
+ * xray.app.proxyman.conf.GetSingBox
+ *
+ * 用户订阅地址, 需要配置订阅密钥, UpdSubscribe 轮换地址, DelSubscribe 吊销
+ * xray.app.proxyman.conf.GetSubscribe
+ * xray.app.proxyman.conf.UpdSubscribe
+ * xray.app.proxyman.conf.DelSubscribe
+ *
+ * 从分享链接导入出站, 每行一个链接或 JSON 数组, prefix 可选
+ * xray.app.proxyman.conf.ImportOutbound
+ *
+ * 远程订阅, prefix 为出站 tag 前缀(不能包含 -), interval 为刷新间隔(秒)
+ * xray.app.proxyman.conf.AddRemote
+ * xray.app.proxyman.conf.DelRemote
+ * xray.app.proxyman.conf.LstRemote
+ * xray.app.proxyman.conf.UpdRemote
+ *
+ * 在线用户, tag 为空列出全部入站; 用户在线IP, email
+ * 启用 statsUserOnline 时, LstInbound 返回 {tag, online}, online 为入站在线用户数量
+ * xray.app.proxyman.conf.LstOnline
+ * xray.app.proxyman.core.LstOnline
+ * xray.app.proxyman.conf.GetOnline
+ * xray.app.proxyman.core.GetOnline
+ *
+ * 用户IP限制, email 为空设置默认限制, limit < 0 删除用户限制
+ * xray.app.proxyman.conf.SetIpLimit
+ * xray.app.proxyman.core.SetIpLimit
+ * xray.app.proxyman.conf.LstIpLimit
+ * xray.app.proxyman.core.LstIpLimit
+ *
+ * 证书库, inbound 中使用 "certificateFile": "cert:<name>" 引用
+ * 上传证书, body 为 {certificate, key} PEM, 替换时重建使用该证书的 inbound
+ * xray.app.proxyman.conf.AddCert
+ * 生成自签名证书, hosts 逗号分隔, days 有效天数
+ * xray.app.proxyman.conf.GenCert
+ * xray.app.proxyman.conf.DelCert
+ * xray.app.proxyman.conf.LstCert
+ *
+ * 事件, type 为空列出全部
+ * xray.app.proxyman.conf.LstEvent
+ * xray.app.proxyman.core.LstEvent
+ *
+ * 流量历史, type=inbound|outbound|user, name, unit=minute|hour|day, from, to
+ * xray.app.proxyman.conf.QryTraffic
+ * xray.app.proxyman.core.QryTraffic
+ *
+ * 访问控制配置, 来自 -acl-file, 修改后自动重新加载
+ * xray.app.proxyman.conf.LstAcl
+ * xray.app.proxyman.core.LstAcl
+ *
+ * 审计日志, from, to, principal, act(action 通配符), tag, limit
+ * xray.app.proxyman.conf.QryAudit
+ * xray.app.proxyman.core.QryAudit
+ *
  */
 func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 	var resp *Result = nil
@@ -182,7 +444,7 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 		xcc := conf.InboundDetourConfig{}
 		if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
 			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
-		} else if err := this.Serve.AddInbound(xcc, false); err != nil {
+		} else if err := this.Serve.AddInbound(xcc, true); err != nil {
 			resp = &Result{ErrCode: "error_add_inbound", Message: "错误: " + err.Error()}
 		} else {
 			resp = &Result{Success: true}
@@ -199,7 +461,17 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 			resp = &Result{Success: true}
 		}
 	// -------------------------------------------------------------------------------
-	case "xray.app.proxyman.conf.DelInbound", "xray.app.proxyman.core.DelInbound":
+	case "xray.app.proxyman.conf.DelInbound":
+		// 删除入站
+		if tag := rr.URL.Query().Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if err := this.Serve.DelInbound(tag, true); err != nil {
+			resp = &Result{ErrCode: "error_del_inbound", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.core.DelInbound":
 		// 删除入站
 		if tag := rr.URL.Query().Get("tag"); tag == "" {
 			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
@@ -211,15 +483,15 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 	// -------------------------------------------------------------------------------
 	case "xray.app.proxyman.conf.LstInbound", "xray.app.proxyman.core.LstInbound":
 		// 列出入站
-		data, _ := this.Serve.LstInbound0()
-		resp = &Result{Success: true, Data: data}
+		data, _ := this.Serve.LstInboundOnline()
+		resp = &Result{Success: true, Data: RequestPrincipal(rr).FilterTags(data)}
 	// -------------------------------------------------------------------------------
 	case "xray.app.proxyman.conf.AddOutbound":
 		// 添加出站
 		xcc := conf.OutboundDetourConfig{}
 		if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
 			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
-		} else if err := this.Serve.AddOutbound(xcc, false); err != nil {
+		} else if err := this.Serve.AddOutbound(xcc, true); err != nil {
 			resp = &Result{ErrCode: "error_add_outbound", Message: "错误: " + err.Error()}
 		} else {
 			resp = &Result{Success: true}
@@ -236,7 +508,17 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 			resp = &Result{Success: true}
 		}
 	// -------------------------------------------------------------------------------
-	case "xray.app.proxyman.conf.DelOutbound", "xray.app.proxyman.core.DelOutbound":
+	case "xray.app.proxyman.conf.DelOutbound":
+		// 删除出站
+		if tag := rr.URL.Query().Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if err := this.Serve.DelOutbound(tag, true); err != nil {
+			resp = &Result{ErrCode: "error_del_outbound"}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.core.DelOutbound":
 		// 删除出站
 		if tag := rr.URL.Query().Get("tag"); tag == "" {
 			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
@@ -249,9 +531,22 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 	case "xray.app.proxyman.conf.LstOutbound", "xray.app.proxyman.core.LstOutbound":
 		// 列出出站
 		data, _ := this.Serve.LstOutbound0()
-		resp = &Result{Success: true, Data: data}
+		resp = &Result{Success: true, Data: RequestPrincipal(rr).FilterTags(data)}
 	// -------------------------------------------------------------------------------
-	case "xray.app.proxyman.conf.AddRoute", "xray.app.proxyman.core.AddRoute":
+	case "xray.app.proxyman.conf.AddRoute":
+		// 添加路由
+		if bts, err := io.ReadAll(rr.Body); err != nil {
+			resp = &Result{ErrCode: "invalid_data", Message: "无效的数据: " + err.Error()}
+		} else {
+			var raw json.RawMessage = bts
+			if err := this.Serve.AddRoute(raw, true); err != nil {
+				resp = &Result{ErrCode: "error_add_route", Message: "错误: " + err.Error()}
+			} else {
+				resp = &Result{Success: true}
+			}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.core.AddRoute":
 		// 添加路由
 		if bts, err := io.ReadAll(rr.Body); err != nil {
 			resp = &Result{ErrCode: "invalid_data", Message: "无效的数据: " + err.Error()}
@@ -264,7 +559,17 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 			}
 		}
 	// -------------------------------------------------------------------------------
-	case "xray.app.proxyman.conf.DelRoute", "xray.app.proxyman.core.DelRoute":
+	case "xray.app.proxyman.conf.DelRoute":
+		// 删除路由
+		if tag := rr.URL.Query().Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if err := this.Serve.DelRoute(tag, true); err != nil {
+			resp = &Result{ErrCode: "error_del_route", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.core.DelRoute":
 		// 删除路由
 		if tag := rr.URL.Query().Get("tag"); tag == "" {
 			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
@@ -277,7 +582,7 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 	case "xray.app.proxyman.conf.LstRoute", "xray.app.proxyman.core.LstRoute":
 		// 列路由
 		data, _ := this.Serve.LstRoute0()
-		resp = &Result{Success: true, Data: data}
+		resp = &Result{Success: true, Data: RequestPrincipal(rr).FilterTags(data)}
 	// -------------------------------------------------------------------------------
 	case "xray.app.proxyman.conf.AddIObound":
 		// 添加入站 & 添加出站
@@ -290,17 +595,11 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 		} else {
 			// 构建路由
 			tag := xcc.Inbound.Tag
-			rule_ := &router.RoutingRule{
-				RuleTag:    tag,
-				TargetTag:  &router.RoutingRule_Tag{Tag: tag},
-				InboundTag: []string{tag},
-			}
-			rul := &router.Config{Rule: []*router.RoutingRule{rule_}}
-			// 部署配置
-			rule := serial.ToTypedMessage(rul)
-			err1 := this.Serve.AddOutbound(xcc.Outbound, false)
-			err2 := this.Serve.AddInbound(xcc.Inbound, false)
-			err3 := this.Serve.AddRoute0(rule)
+			rule, _ := json.Marshal(map[string]any{"ruleTag": tag, "inboundTag": []string{tag}, "outboundTag": tag})
+			// 部署配置, 同步到配置中
+			err1 := this.Serve.AddOutbound(xcc.Outbound, true)
+			err2 := this.Serve.AddInbound(xcc.Inbound, true)
+			err3 := this.Serve.AddRoute(rule, true)
 			if err1 != nil || err2 != nil || err3 != nil {
 				rmsg := fmt.Sprintf("错误: %s(tag) -> %v(out), %v(in), %v(route)", tag, err1, err2, err3)
 				resp = &Result{ErrCode: "error_del_iobound", Message: rmsg}
@@ -339,7 +638,23 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 			}
 		}
 	// -------------------------------------------------------------------------------
-	case "xray.app.proxyman.conf.DelIObound", "xray.app.proxyman.core.DelIObound":
+	case "xray.app.proxyman.conf.DelIObound":
+		// 删除入站 & 删除出站
+		if tag := rr.URL.Query().Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else {
+			err1 := this.Serve.DelOutbound(tag, true)
+			err2 := this.Serve.DelInbound(tag, true)
+			err3 := this.Serve.DelRoute(tag, true)
+			if err1 != nil || err2 != nil || err3 != nil {
+				rmsg := fmt.Sprintf("错误: %s(tag) -> %v(out), %v(in), %v(route)", tag, err1, err2, err3)
+				resp = &Result{ErrCode: "error_del_iobound", Message: rmsg}
+			} else {
+				resp = &Result{Success: true}
+			}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.core.DelIObound":
 		// 删除入站 & 删除出站
 		if tag := rr.URL.Query().Get("tag"); tag == "" {
 			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
@@ -355,8 +670,392 @@ func (this *Worker) xrayz(ac string, ww http.ResponseWriter, rr *http.Request) {
 			}
 		}
 	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.SaveConf":
+		// 保存配置
+		if msg := this.Serve.SaveXray(); msg != "" {
+			resp = &Result{ErrCode: "error_save_conf", Message: "错误: " + msg}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
 	case "xray.app.proxyman.conf.GetSysStats", "xray.app.proxyman.core.GetSysStats":
 		resp = &Result{Success: true, Data: this.Serve.GetSysStats()}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstPolicy", "xray.app.proxyman.core.LstPolicy":
+		// 列出策略
+		if data, err := this.Serve.LstPolicy(); err != nil {
+			resp = &Result{ErrCode: "error_lst_policy", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.SetPolicy":
+		// 创建或修改用户等级策略
+		xcc := conf.Policy{}
+		if level, err := strconv.ParseUint(rr.URL.Query().Get("level"), 10, 32); err != nil {
+			resp = &Result{ErrCode: "invalid_level", Message: "无效的 level"}
+		} else if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
+			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
+		} else if err := this.Serve.SetPolicy(uint32(level), &xcc); err != nil {
+			resp = &Result{ErrCode: "error_set_policy", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.DelPolicy":
+		// 删除用户等级策略
+		if level, err := strconv.ParseUint(rr.URL.Query().Get("level"), 10, 32); err != nil {
+			resp = &Result{ErrCode: "invalid_level", Message: "无效的 level"}
+		} else if err := this.Serve.DelPolicy(uint32(level)); err != nil {
+			resp = &Result{ErrCode: "error_del_policy", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.SetSysPolicy":
+		// 修改系统策略
+		xcc := conf.SystemPolicy{}
+		if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
+			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
+		} else if err := this.Serve.SetSysPolicy(&xcc); err != nil {
+			resp = &Result{ErrCode: "error_set_policy", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.AddUser":
+		// 添加用户
+		query := rr.URL.Query()
+		level, xcc := -1, map[string]any{}
+		if lvl := query.Get("level"); lvl != "" {
+			if val, err := strconv.ParseUint(lvl, 10, 32); err == nil {
+				level = int(val)
+			} else {
+				level = -2
+			}
+		}
+		if tag := query.Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if level == -2 {
+			resp = &Result{ErrCode: "invalid_level", Message: "无效的 level"}
+		} else if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
+			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
+		} else if err := this.Serve.AddUser(tag, xcc, level); err != nil {
+			resp = &Result{ErrCode: "error_add_user", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.DelUser":
+		// 删除用户
+		query := rr.URL.Query()
+		if tag := query.Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if email := query.Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if err := this.Serve.DelUser(tag, email); err != nil {
+			resp = &Result{ErrCode: "error_del_user", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetShareLink", "xray.app.proxyman.core.GetShareLink":
+		// 分享链接
+		query := rr.URL.Query()
+		if tag := query.Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if host := query.Get("host"); host == "" {
+			resp = &Result{ErrCode: "invalid_host", Message: "无效的 host"}
+		} else if info, err := this.Serve.ShareInfo(tag, query.Get("email"), host); err != nil {
+			resp = &Result{ErrCode: "error_share_link", Message: "错误: " + err.Error()}
+		} else if link, err := info.Link(); err != nil {
+			resp = &Result{ErrCode: "error_share_link", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: link}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetQrCode", "xray.app.proxyman.core.GetQrCode":
+		// 分享链接二维码
+		query := rr.URL.Query()
+		if tag := query.Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if host := query.Get("host"); host == "" {
+			resp = &Result{ErrCode: "invalid_host", Message: "无效的 host"}
+		} else if opt, err := NewQrOption(query.Get("format"), query.Get("size"), query.Get("level")); err != nil {
+			resp = &Result{ErrCode: "invalid_qrcode", Message: err.Error()}
+		} else if info, err := this.Serve.ShareInfo(tag, query.Get("email"), host); err != nil {
+			resp = &Result{ErrCode: "error_share_link", Message: "错误: " + err.Error()}
+		} else if link, err := info.Link(); err != nil {
+			resp = &Result{ErrCode: "error_share_link", Message: "错误: " + err.Error()}
+		} else if data, ctype, err := opt.Encode(link); err != nil {
+			resp = &Result{ErrCode: "error_qrcode", Message: "错误: " + err.Error()}
+		} else {
+			ww.Header().Set("Content-Type", ctype)
+			ww.Write(data)
+			return
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetClientConf", "xray.app.proxyman.core.GetClientConf":
+		// 客户端完整配置
+		query := rr.URL.Query()
+		opt := &ClientOption{Listen: query.Get("listen"), SocksPort: 10808, HttpPort: 10809}
+		if opt.Listen == "" {
+			opt.Listen = "127.0.0.1"
+		}
+		port := func(key string, val *uint32) bool {
+			if str := query.Get(key); str != "" {
+				num, err := strconv.ParseUint(str, 10, 16)
+				*val = uint32(num)
+				return err == nil
+			}
+			return true
+		}
+		if tag := query.Get("tag"); tag == "" {
+			resp = &Result{ErrCode: "invalid_tag", Message: "无效的 tag"}
+		} else if host := query.Get("host"); host == "" {
+			resp = &Result{ErrCode: "invalid_host", Message: "无效的 host"}
+		} else if !port("socks", &opt.SocksPort) || !port("http", &opt.HttpPort) {
+			resp = &Result{ErrCode: "invalid_port", Message: "无效的端口"}
+		} else if info, err := this.Serve.ShareInfo(tag, query.Get("email"), host); err != nil {
+			resp = &Result{ErrCode: "error_share_link", Message: "错误: " + err.Error()}
+		} else if xcc, err := ClientConfig(info, opt); err != nil {
+			resp = &Result{ErrCode: "error_client_conf", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: xcc}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetClash", "xray.app.proxyman.core.GetClash":
+		// 导出 Clash/Mihomo 配置
+		query := rr.URL.Query()
+		if email := query.Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if host := query.Get("host"); host == "" {
+			resp = &Result{ErrCode: "invalid_host", Message: "无效的 host"}
+		} else {
+			infos, errs := this.Serve.UserShareInfos(email, host)
+			if data, errs_, err := ClashProfile(infos); err != nil {
+				resp = &Result{ErrCode: "error_clash", Message: "错误: " + err.Error()}
+			} else {
+				resp = &Result{Success: true, Data: map[string]any{"profile": string(data), "errors": append(errs, errs_...)}}
+			}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetSingBox", "xray.app.proxyman.core.GetSingBox":
+		// 导出 sing-box 出站配置
+		query := rr.URL.Query()
+		if email := query.Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if host := query.Get("host"); host == "" {
+			resp = &Result{ErrCode: "invalid_host", Message: "无效的 host"}
+		} else {
+			infos, errs := this.Serve.UserShareInfos(email, host)
+			if data, errs_, err := SingBoxProfile(infos); err != nil {
+				resp = &Result{ErrCode: "error_singbox", Message: "错误: " + err.Error()}
+			} else {
+				resp = &Result{Success: true, Data: map[string]any{"profile": json.RawMessage(data), "errors": append(errs, errs_...)}}
+			}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetSubscribe", "xray.app.proxyman.core.GetSubscribe":
+		// 用户订阅地址
+		if email := rr.URL.Query().Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if this.Subscribe.Key == "" {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: 未配置订阅密钥"}
+		} else if token, err := this.Subscribe.Token(email); err != nil {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: map[string]string{"token": token, "path": this.Subscribe.Path + token}}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.UpdSubscribe":
+		// 轮换用户订阅地址, 旧地址失效
+		if email := rr.URL.Query().Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if this.Subscribe.Key == "" {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: 未配置订阅密钥"}
+		} else if token, err := this.Subscribe.Rotate(email); err != nil {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: map[string]string{"token": token, "path": this.Subscribe.Path + token}}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.DelSubscribe":
+		// 吊销用户订阅, 轮换后恢复
+		if email := rr.URL.Query().Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if this.Subscribe.Key == "" {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: 未配置订阅密钥"}
+		} else if err := this.Subscribe.Revoke(email); err != nil {
+			resp = &Result{ErrCode: "error_subscribe", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.ImportOutbound":
+		// 从分享链接导入出站
+		if body, err := io.ReadAll(rr.Body); err != nil {
+			resp = &Result{ErrCode: "invalid_body", Message: "无效的请求: " + err.Error()}
+		} else if links := SplitShareLinks(body); len(links) == 0 {
+			resp = &Result{ErrCode: "invalid_link", Message: "无效的链接"}
+		} else {
+			resp = &Result{Success: true, Data: this.Serve.ImportOutbound(links, rr.URL.Query().Get("prefix"))}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.AddRemote":
+		// 添加远程订阅
+		query := rr.URL.Query()
+		interval, _ := strconv.Atoi(query.Get("interval"))
+		if prefix := query.Get("prefix"); prefix == "" {
+			resp = &Result{ErrCode: "invalid_prefix", Message: "无效的 prefix"}
+		} else if src, err := this.Remote.Add(&this.Serve, &this.Events, prefix, query.Get("url"), interval); err != nil {
+			resp = &Result{ErrCode: "error_add_remote", Message: "错误: " + err.Error(), Data: src}
+		} else {
+			resp = &Result{Success: true, Data: src}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.DelRemote":
+		// 删除远程订阅
+		if err := this.Remote.Del(&this.Serve, rr.URL.Query().Get("prefix")); err != nil {
+			resp = &Result{ErrCode: "error_del_remote", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstRemote", "xray.app.proxyman.core.LstRemote":
+		// 列出远程订阅
+		resp = &Result{Success: true, Data: this.Remote.List()}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.UpdRemote":
+		// 立即刷新远程订阅
+		if err := this.Remote.Refresh(&this.Serve, &this.Events, rr.URL.Query().Get("prefix")); err != nil {
+			resp = &Result{ErrCode: "error_upd_remote", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstOnline", "xray.app.proxyman.core.LstOnline":
+		// 列出在线用户
+		if data, err := this.Serve.LstOnline(rr.URL.Query().Get("tag")); err != nil {
+			resp = &Result{ErrCode: "error_lst_online", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GetOnline", "xray.app.proxyman.core.GetOnline":
+		// 用户在线IP
+		if email := rr.URL.Query().Get("email"); email == "" {
+			resp = &Result{ErrCode: "invalid_email", Message: "无效的 email"}
+		} else if data, err := this.Serve.GetOnlineIps(email); err != nil {
+			resp = &Result{ErrCode: "error_get_online", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.SetIpLimit", "xray.app.proxyman.core.SetIpLimit":
+		// 设置用户IP限制
+		if limit, err := strconv.Atoi(rr.URL.Query().Get("limit")); err != nil {
+			resp = &Result{ErrCode: "invalid_limit", Message: "无效的 limit"}
+		} else {
+			this.IpLimit.SetLimit(rr.URL.Query().Get("email"), limit)
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstIpLimit", "xray.app.proxyman.core.LstIpLimit":
+		// 列出用户IP限制
+		resp = &Result{Success: true, Data: this.IpLimit.List()}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.AddCert":
+		// 上传证书
+		xcc := struct {
+			Certificate string `json:"certificate"`
+			Key         string `json:"key"`
+		}{}
+		if name := rr.URL.Query().Get("name"); name == "" {
+			resp = &Result{ErrCode: "invalid_name", Message: "无效的 name"}
+		} else if err := json.NewDecoder(rr.Body).Decode(&xcc); err != nil {
+			resp = &Result{ErrCode: "invalid_json", Message: "无效的 JSON: " + err.Error()}
+		} else if data, err := this.Certs.Put(&this.Serve, &this.Events, name, []byte(xcc.Certificate), []byte(xcc.Key)); err != nil {
+			resp = &Result{ErrCode: "error_add_cert", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.GenCert":
+		// 生成自签名证书
+		query := rr.URL.Query()
+		days, _ := strconv.Atoi(query.Get("days"))
+		hosts := []string{}
+		for _, host := range strings.Split(query.Get("hosts"), ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+		if name := query.Get("name"); name == "" {
+			resp = &Result{ErrCode: "invalid_name", Message: "无效的 name"}
+		} else if data, err := this.Certs.Generate(&this.Serve, &this.Events, name, hosts, days); err != nil {
+			resp = &Result{ErrCode: "error_gen_cert", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.DelCert":
+		// 删除证书
+		if err := this.Certs.Del(&this.Serve, rr.URL.Query().Get("name")); err != nil {
+			resp = &Result{ErrCode: "error_del_cert", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstCert", "xray.app.proxyman.core.LstCert":
+		// 列出证书
+		if data, err := this.Certs.List(&this.Serve); err != nil {
+			resp = &Result{ErrCode: "error_lst_cert", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstEvent", "xray.app.proxyman.core.LstEvent":
+		// 列出事件
+		resp = &Result{Success: true, Data: this.Events.List(rr.URL.Query().Get("type"))}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.QryTraffic", "xray.app.proxyman.core.QryTraffic":
+		// 查询流量历史
+		query := rr.URL.Query()
+		unit := query.Get("unit")
+		if unit == "" {
+			unit = "hour"
+		}
+		now := time.Now()
+		if from, err := ParseTime(query.Get("from"), now.Add(-24*time.Hour)); err != nil {
+			resp = &Result{ErrCode: "invalid_time", Message: "无效的时间: " + err.Error()}
+		} else if to, err := ParseTime(query.Get("to"), now); err != nil {
+			resp = &Result{ErrCode: "invalid_time", Message: "无效的时间: " + err.Error()}
+		} else if data, err := this.Traffic.Query(query.Get("type"), query.Get("name"), unit, from, to); err != nil {
+			resp = &Result{ErrCode: "error_qry_traffic", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.LstAcl", "xray.app.proxyman.core.LstAcl":
+		// 访问控制配置
+		resp = &Result{Success: true, Data: this.Acl.List()}
+	// -------------------------------------------------------------------------------
+	case "xray.app.proxyman.conf.QryAudit", "xray.app.proxyman.core.QryAudit":
+		// 查询审计日志
+		query := rr.URL.Query()
+		filter := &AuditFilter{Principal: query.Get("principal"), Action: query.Get("act"), Tag: query.Get("tag")}
+		filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+		var err error
+		if filter.From, err = ParseTime(query.Get("from"), time.Time{}); err != nil {
+			resp = &Result{ErrCode: "invalid_time", Message: "无效的时间: " + err.Error()}
+		} else if filter.To, err = ParseTime(query.Get("to"), time.Now()); err != nil {
+			resp = &Result{ErrCode: "invalid_time", Message: "无效的时间: " + err.Error()}
+		} else if data, err := this.Audit.Query(filter); err != nil {
+			resp = &Result{ErrCode: "error_qry_audit", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true, Data: data}
+		}
 	}
 	// -------------------------------------------------------------------------------
 	if resp == nil {
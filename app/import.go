@@ -0,0 +1,374 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+/**
+ * 导入结果
+ */
+type ImportResult struct {
+	Link    string `json:"link"`
+	Tag     string `json:"tag,omitempty"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+}
+
+/**
+ * 解码 base64, 兼容 标准/URL 及 有无填充
+ */
+func DecodeBase64(str string) ([]byte, error) {
+	str = strings.TrimSpace(str)
+	str = strings.TrimRight(str, "=")
+	if bts, err := base64.RawStdEncoding.DecodeString(str); err == nil {
+		return bts, nil
+	}
+	return base64.RawURLEncoding.DecodeString(str)
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 解析分享链接, 支持 vless, vmess, trojan, ss, socks, http
+ */
+func ParseShareLink(link string) (*ShareInfo, error) {
+	link = strings.TrimSpace(link)
+	scheme, _, ok := strings.Cut(link, "://")
+	if !ok {
+		return nil, errors.New("无效的链接")
+	}
+	switch strings.ToLower(scheme) {
+	case "vmess":
+		return parseVMessLink(link)
+	case "ss":
+		return parseShadowsocksLink(link)
+	}
+	uri, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	info, err := parseLinkAddress(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(scheme) {
+	case "vless":
+		info.Protocol, info.Id = "vless", uri.User.Username()
+		if enc := uri.Query().Get("encryption"); enc != "" && enc != "none" {
+			return nil, errors.New("不支持 VLESS encryption: " + enc)
+		}
+		info.Flow = uri.Query().Get("flow")
+	case "trojan":
+		info.Protocol, info.Password = "trojan", uri.User.Username()
+	case "socks", "socks5":
+		info.Protocol = "socks"
+		if uri.User != nil {
+			info.User, _ = url.PathUnescape(uri.User.Username())
+			info.Password, _ = uri.User.Password()
+			if _, ok := uri.User.Password(); !ok {
+				// v2rayN 格式: base64(user:pass)
+				if bts, err := DecodeBase64(info.User); err == nil {
+					info.User, info.Password, _ = strings.Cut(string(bts), ":")
+				}
+			}
+		}
+		return info, nil
+	case "http", "https":
+		info.Protocol = "http"
+		if uri.User != nil {
+			info.User = uri.User.Username()
+			info.Password, _ = uri.User.Password()
+		}
+		if scheme == "https" {
+			info.Security, info.Sni = "tls", uri.Hostname()
+		}
+		return info, nil
+	default:
+		return nil, errors.New("不支持的链接: " + scheme)
+	}
+	if uri.User == nil || uri.User.Username() == "" {
+		return nil, errors.New("链接缺少用户")
+	}
+	info.linkQuery(uri.Query())
+	return info, nil
+}
+
+func parseLinkAddress(uri *url.URL) (*ShareInfo, error) {
+	port, err := strconv.ParseUint(uri.Port(), 10, 16)
+	if err != nil {
+		return nil, errors.New("无效的端口: " + uri.Port())
+	}
+	info := &ShareInfo{Address: uri.Hostname(), Port: uint32(port), Remark: uri.Fragment, Network: "tcp", Security: "none"}
+	return info, nil
+}
+
+/**
+ * 解析链接中的传输参数
+ */
+func (info *ShareInfo) linkQuery(query url.Values) {
+	if val := query.Get("type"); val != "" {
+		info.Network = val
+	}
+	if val := query.Get("security"); val != "" {
+		info.Security = val
+	}
+	info.HeaderType = query.Get("headerType")
+	info.Path = query.Get("path")
+	info.Host = query.Get("host")
+	info.ServiceName = query.Get("serviceName")
+	info.Mode = query.Get("mode")
+	info.Seed = query.Get("seed")
+	info.Sni = query.Get("sni")
+	info.Fingerprint = query.Get("fp")
+	if alpn := query.Get("alpn"); alpn != "" {
+		info.Alpn = strings.Split(alpn, ",")
+	}
+	info.Insecure = query.Get("allowInsecure") == "1" || query.Get("insecure") == "1"
+	info.PublicKey = query.Get("pbk")
+	info.ShortId = query.Get("sid")
+	info.SpiderX = query.Get("spx")
+}
+
+func parseVMessLink(link string) (*ShareInfo, error) {
+	bts, err := DecodeBase64(link[len("vmess://"):])
+	if err != nil {
+		return nil, errors.New("无效的 vmess 链接")
+	}
+	vmess := map[string]any{}
+	if err := json.Unmarshal(bts, &vmess); err != nil {
+		return nil, errors.New("无效的 vmess 链接: " + err.Error())
+	}
+	str := func(key string) string {
+		switch val := vmess[key].(type) {
+		case string:
+			return val
+		case float64:
+			return strconv.FormatFloat(val, 'f', -1, 64)
+		}
+		return ""
+	}
+	port, err := strconv.ParseUint(str("port"), 10, 16)
+	if err != nil {
+		return nil, errors.New("无效的端口: " + str("port"))
+	}
+	info := &ShareInfo{
+		Remark: str("ps"), Protocol: "vmess", Address: str("add"), Port: uint32(port), Id: str("id"),
+		Network: str("net"), Path: str("path"), Host: str("host"), Sni: str("sni"), Fingerprint: str("fp"),
+		Security: "none",
+	}
+	if info.Network == "" {
+		info.Network = "tcp"
+	}
+	switch info.Network {
+	case "grpc":
+		info.ServiceName, info.Path, info.Mode = info.Path, "", str("type")
+	case "kcp":
+		info.Seed, info.Path, info.HeaderType = info.Path, "", str("type")
+	case "xhttp":
+		info.Mode = str("type")
+	default:
+		info.HeaderType = str("type")
+	}
+	if info.HeaderType == "none" {
+		info.HeaderType = ""
+	}
+	if str("tls") == "tls" {
+		info.Security = "tls"
+	}
+	if alpn := str("alpn"); alpn != "" {
+		info.Alpn = strings.Split(alpn, ",")
+	}
+	return info, nil
+}
+
+func parseShadowsocksLink(link string) (*ShareInfo, error) {
+	body, remark, _ := strings.Cut(link[len("ss://"):], "#")
+	body, rawq, _ := strings.Cut(body, "?")
+	body = strings.TrimSuffix(body, "/") // SIP002: ss://userinfo@host:port/?plugin=...
+	if query, err := url.ParseQuery(rawq); err != nil {
+		return nil, errors.New("无效的 ss 链接")
+	} else if plugin := query.Get("plugin"); plugin != "" {
+		return nil, errors.New("不支持 ss plugin: " + plugin)
+	}
+	remark, _ = url.PathUnescape(remark)
+	user, addr, ok := strings.Cut(body, "@")
+	if !ok {
+		// 旧格式: base64(method:password@host:port)
+		bts, err := DecodeBase64(body)
+		if err != nil {
+			return nil, errors.New("无效的 ss 链接")
+		}
+		idx := strings.LastIndexByte(string(bts), '@')
+		if idx < 0 {
+			return nil, errors.New("无效的 ss 链接")
+		}
+		user, addr = string(bts[:idx]), string(bts[idx+1:])
+	} else if bts, err := DecodeBase64(user); err == nil && strings.Contains(string(bts), ":") {
+		user = string(bts)
+	} else if user, err = url.PathUnescape(user); err != nil {
+		return nil, errors.New("无效的 ss 链接")
+	}
+	method, password, ok := strings.Cut(user, ":")
+	if !ok {
+		return nil, errors.New("无效的 ss 链接")
+	}
+	host, portstr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.New("无效的地址: " + addr)
+	}
+	port, err := strconv.ParseUint(portstr, 10, 16)
+	if err != nil {
+		return nil, errors.New("无效的端口: " + portstr)
+	}
+	password, _ = url.PathUnescape(password)
+	return &ShareInfo{
+		Remark: remark, Protocol: "shadowsocks", Address: host, Port: uint32(port),
+		Method: method, Password: password, Network: "tcp", Security: "none",
+	}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 转换为 outbound 配置
+ */
+func (info *ShareInfo) Outbound(tag string) (conf.OutboundDetourConfig, error) {
+	cotb := conf.OutboundDetourConfig{}
+	data, err := info.OutboundJSON(tag)
+	if err != nil {
+		return cotb, err
+	}
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return cotb, err
+	}
+	err = json.Unmarshal(bts, &cotb)
+	return cotb, err
+}
+
+/**
+ * 转换为 outbound 配置(JSON 结构), 只包含需要的字段
+ */
+func (info *ShareInfo) OutboundJSON(tag string) (map[string]any, error) {
+	sets := map[string]any{"address": info.Address, "port": info.Port}
+	switch info.Protocol {
+	case "vless":
+		sets["id"], sets["flow"], sets["encryption"] = info.Id, info.Flow, "none"
+	case "vmess":
+		sets["id"], sets["security"] = info.Id, "auto"
+	case "trojan":
+		sets["password"] = info.Password
+	case "shadowsocks":
+		sets["method"], sets["password"] = info.Method, info.Password
+	case "socks", "http":
+		if info.User != "" {
+			sets["user"], sets["pass"] = info.User, info.Password
+		}
+	default:
+		return nil, errors.New("不支持的协议: " + info.Protocol)
+	}
+	stream := map[string]any{"network": info.Network, "security": info.Security}
+	switch info.Network {
+	case "tcp", "raw":
+		if info.HeaderType == "http" {
+			req := map[string]any{"path": []string{info.Path}}
+			if info.Host != "" {
+				req["headers"] = map[string][]string{"Host": strings.Split(info.Host, ",")}
+			}
+			stream["tcpSettings"] = map[string]any{"header": map[string]any{"type": "http", "request": req}}
+		}
+	case "ws":
+		stream["wsSettings"] = map[string]any{"path": info.Path, "host": info.Host}
+	case "httpupgrade":
+		stream["httpupgradeSettings"] = map[string]any{"path": info.Path, "host": info.Host}
+	case "xhttp", "splithttp":
+		stream["xhttpSettings"] = map[string]any{"path": info.Path, "host": info.Host, "mode": info.Mode}
+	case "grpc":
+		stream["grpcSettings"] = map[string]any{"serviceName": info.ServiceName, "authority": info.Host, "multiMode": info.Mode == "multi"}
+	case "kcp", "mkcp":
+		kcp := map[string]any{"seed": info.Seed}
+		if info.HeaderType != "" {
+			kcp["header"] = map[string]any{"type": info.HeaderType}
+		}
+		stream["kcpSettings"] = kcp
+	default:
+		return nil, errors.New("不支持的传输: " + info.Network)
+	}
+	switch info.Security {
+	case "", "none":
+	case "tls":
+		tls := map[string]any{"serverName": info.Sni, "fingerprint": info.Fingerprint, "allowInsecure": info.Insecure}
+		if len(info.Alpn) > 0 {
+			tls["alpn"] = info.Alpn
+		}
+		stream["tlsSettings"] = tls
+	case "reality":
+		stream["realitySettings"] = map[string]any{
+			"serverName": info.Sni, "fingerprint": info.Fingerprint,
+			"publicKey": info.PublicKey, "shortId": info.ShortId, "spiderX": info.SpiderX,
+		}
+	default:
+		return nil, errors.New("不支持的安全类型: " + info.Security)
+	}
+	return map[string]any{"tag": tag, "protocol": info.Protocol, "settings": sets, "streamSettings": stream}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 拆分链接, 支持 JSON 数组 或 每行一个链接
+ */
+func SplitShareLinks(body []byte) []string {
+	links := []string{}
+	if err := json.Unmarshal(body, &links); err == nil {
+		return links
+	}
+	for _, line := range strings.Split(string(body), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			links = append(links, line)
+		}
+	}
+	return links
+}
+
+/**
+ * 导入出站, prefix 不为空时使用 prefix-序号 作为 tag, 否则使用链接备注
+ */
+func (this *XrayServe) ImportOutbound(links []string, prefix string) []*ImportResult {
+	results := []*ImportResult{}
+	for idx, link := range links {
+		result := &ImportResult{Link: link}
+		results = append(results, result)
+		info, err := ParseShareLink(link)
+		if err != nil {
+			result.Message = "解析失败: " + err.Error()
+			continue
+		}
+		result.Tag = info.Remark
+		if prefix != "" {
+			result.Tag = fmt.Sprintf("%s-%d", prefix, idx+1)
+		} else if result.Tag == "" {
+			result.Tag = net.JoinHostPort(info.Address, strconv.Itoa(int(info.Port)))
+		}
+		cotb, err := info.Outbound(result.Tag)
+		if err != nil {
+			result.Message = "转换失败: " + err.Error()
+			continue
+		}
+		if err := this.AddOutbound(cotb, true); err != nil {
+			result.Message = "添加失败: " + err.Error()
+			continue
+		}
+		result.Success = true
+	}
+	return results
+}
@@ -0,0 +1,129 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestParseShareLink(t *testing.T) {
+	vmess := base64.StdEncoding.EncodeToString([]byte(`{"v":"2","ps":"vm","add":"1.2.3.4","port":"443","id":"a3482e88-686a-4a58-8126-99c9df64b7bf","net":"ws","path":"/ws","host":"example.com","tls":"tls","sni":"example.com"}`))
+	ssuser := base64.RawURLEncoding.EncodeToString([]byte("aes-256-gcm:pass"))
+	cases := []struct {
+		name string
+		link string
+		want *ShareInfo
+		err  string
+	}{
+		{
+			name: "vless reality",
+			link: "vless://a3482e88-686a-4a58-8126-99c9df64b7bf@example.com:443?type=tcp&security=reality&flow=xtls-rprx-vision&sni=www.microsoft.com&fp=chrome&pbk=pub&sid=ab#vl",
+			want: &ShareInfo{
+				Remark: "vl", Protocol: "vless", Address: "example.com", Port: 443, Id: "a3482e88-686a-4a58-8126-99c9df64b7bf",
+				Flow: "xtls-rprx-vision", Network: "tcp", Security: "reality", Sni: "www.microsoft.com", Fingerprint: "chrome",
+				PublicKey: "pub", ShortId: "ab",
+			},
+		},
+		{
+			name: "vmess ws tls",
+			link: "vmess://" + vmess,
+			want: &ShareInfo{
+				Remark: "vm", Protocol: "vmess", Address: "1.2.3.4", Port: 443, Id: "a3482e88-686a-4a58-8126-99c9df64b7bf",
+				Network: "ws", Path: "/ws", Host: "example.com", Security: "tls", Sni: "example.com",
+			},
+		},
+		{
+			name: "trojan",
+			link: "trojan://secret@example.com:8443?security=tls&sni=example.com#tj",
+			want: &ShareInfo{
+				Remark: "tj", Protocol: "trojan", Address: "example.com", Port: 8443, Password: "secret",
+				Network: "tcp", Security: "tls", Sni: "example.com",
+			},
+		},
+		{
+			name: "ss sip002",
+			link: "ss://" + ssuser + "@example.com:8388/#ss",
+			want: &ShareInfo{
+				Remark: "ss", Protocol: "shadowsocks", Address: "example.com", Port: 8388,
+				Method: "aes-256-gcm", Password: "pass", Network: "tcp", Security: "none",
+			},
+		},
+		{
+			name: "ss legacy",
+			link: "ss://" + base64.StdEncoding.EncodeToString([]byte("aes-128-gcm:p@ss@10.0.0.1:8388")) + "#old",
+			want: &ShareInfo{
+				Remark: "old", Protocol: "shadowsocks", Address: "10.0.0.1", Port: 8388,
+				Method: "aes-128-gcm", Password: "p@ss", Network: "tcp", Security: "none",
+			},
+		},
+		{name: "ss plugin", link: "ss://" + ssuser + "@example.com:8388/?plugin=obfs-local%3Bobfs%3Dhttp#ss", err: "plugin"},
+		{name: "no scheme", link: "example.com:443", err: "无效的链接"},
+		{name: "unknown scheme", link: "foo://bar@example.com:1", err: "不支持"},
+		{name: "vless bad port", link: "vless://id@example.com:99999", err: "端口"},
+		{name: "vless encryption", link: "vless://id@example.com:443?encryption=mlkem768", err: "encryption"},
+		{name: "vmess bad base64", link: "vmess://!!!", err: "vmess"},
+		{name: "vmess bad json", link: "vmess://" + base64.StdEncoding.EncodeToString([]byte("{")), err: "vmess"},
+		{name: "ss no method", link: "ss://" + base64.StdEncoding.EncodeToString([]byte("pass")) + "@example.com:8388", err: "ss"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := ParseShareLink(tc.link)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("want error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info, tc.want) {
+				t.Fatalf("got  %+v\nwant %+v", info, tc.want)
+			}
+		})
+	}
+}
+
+func TestShareLinkRoundTrip(t *testing.T) {
+	info := &ShareInfo{
+		Remark: "rt", Protocol: "vless", Address: "example.com", Port: 443, Id: "a3482e88-686a-4a58-8126-99c9df64b7bf",
+		Network: "ws", Path: "/ws", Host: "example.com", Security: "tls", Sni: "example.com",
+	}
+	link, err := info.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := ParseShareLink(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Id != info.Id || back.Address != info.Address || back.Port != info.Port || back.Path != info.Path || back.Security != info.Security {
+		t.Fatalf("round trip mismatch: %s -> %+v", link, back)
+	}
+}
+
+func TestShareTrojanTls(t *testing.T) {
+	share := func(stream string) (*ShareInfo, error) {
+		cinb := conf.InboundDetourConfig{}
+		text := `{"tag": "tj", "port": 443, "protocol": "trojan", "settings": {"clients": [{"password": "p", "email": "a@tj"}]}` + stream + `}`
+		if err := json.Unmarshal([]byte(text), &cinb); err != nil {
+			t.Fatal(err)
+		}
+		return NewShareInfo(&cinb, "a@tj", "example.com")
+	}
+	// 未启用 TLS 时不生成明文链接
+	if info, err := share(""); err == nil {
+		t.Fatalf("plaintext trojan shared: %+v", info)
+	}
+	info, err := share(`, "streamSettings": {"security": "tls", "tlsSettings": {"serverName": "example.com"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link, err := info.Link(); err != nil || !strings.Contains(link, "security=tls") {
+		t.Fatalf("link: %s, %v", link, err)
+	}
+}
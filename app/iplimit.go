@@ -0,0 +1,310 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/core"
+)
+
+/**
+ * 在线IP的有效时长, 与 Xray 在线IP的过期时间一致, 超过时长未出现的IP不计数
+ */
+const IpLimitSeen = 20 * time.Second
+
+/**
+ * 用户IP封禁
+ */
+type IpBlock struct {
+	Email string   `json:"email"`
+	Ips   []string `json:"ips"`
+	Until string   `json:"until"`
+
+	until time.Time
+}
+
+/**
+ * 用户设备(IP)数量限制, 依赖 policy 中的 statsUserOnline
+ * 超出限制的新IP通过路由规则转发到封禁出站, 冷却后自动解除
+ */
+type IpLimiter struct {
+	Limit    int           // 每个用户最大IP数量, 0 不限制
+	Cooldown time.Duration // 封禁时长
+	Outbound string        // 封禁出站
+	Interval time.Duration // 检测间隔
+
+	lock   sync.Mutex
+	users  map[string]int                  // 用户单独限制
+	first  map[string]map[string]time.Time // email -> ip -> 首次出现时间
+	blocks map[string]*IpBlock             // email -> 封禁
+	frees  map[string]time.Time            // email -> 解除封禁时间, 之前出现的IP不计数
+	xray   *core.Instance                  // 添加封禁路由的实例, Xray 重新加载后需重新添加
+	stop   chan struct{}
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 设置用户限制, email 为空设置默认限制, limit < 0 删除用户限制
+ */
+func (this *IpLimiter) SetLimit(email string, limit int) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if email == "" {
+		this.Limit = max(limit, 0)
+	} else if limit < 0 {
+		delete(this.users, email)
+	} else {
+		if this.users == nil {
+			this.users = map[string]int{}
+		}
+		this.users[email] = limit
+	}
+}
+
+/**
+ * 获取用户限制
+ */
+func (this *IpLimiter) GetLimit(email string) int {
+	if limit, ok := this.users[email]; ok {
+		return limit
+	}
+	return this.Limit
+}
+
+/**
+ * 列出限制及封禁
+ */
+func (this *IpLimiter) List() map[string]any {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	users := map[string]int{}
+	for email, limit := range this.users {
+		users[email] = limit
+	}
+	blocks := []*IpBlock{}
+	for _, blk := range this.blocks {
+		blocks = append(blocks, blk)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Email < blocks[j].Email })
+	return map[string]any{
+		"limit":    this.Limit,
+		"cooldown": int(this.Cooldown / time.Second),
+		"users":    users,
+		"blocks":   blocks,
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 启动检测
+ */
+func (this *IpLimiter) Start(serve *XrayServe, events *Events) {
+	if this.Interval <= 0 {
+		this.Interval = 10 * time.Second
+	}
+	if this.Outbound == "" {
+		this.Outbound = "blocked"
+	}
+	this.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(this.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-this.stop:
+				return
+			case now := <-ticker.C:
+				if serve.IsRunning() {
+					this.Check(serve, events, now)
+				}
+			}
+		}
+	}()
+}
+
+/**
+ * 停止检测
+ */
+func (this *IpLimiter) Close() {
+	if this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+}
+
+/**
+ * 检测用户IP数量
+ */
+func (this *IpLimiter) Check(serve *XrayServe, events *Events, now time.Time) {
+	mng, err := serve.StatsManager()
+	if err != nil {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.first == nil {
+		this.first = map[string]map[string]time.Time{}
+		this.blocks = map[string]*IpBlock{}
+		this.frees = map[string]time.Time{}
+	}
+	// Xray 重新加载后封禁路由已不存在, 重新添加未到期的封禁
+	if xray := serve.Instance(); this.xray != xray {
+		this.xray = xray
+		for email, blk := range this.blocks {
+			if !now.Before(blk.until) {
+				continue // 到期的封禁下面解除
+			}
+			if err := this.route(serve, blk); err != nil {
+				fmt.Printf("恢复IP限制失败: %s, %s\n", email, err.Error())
+				delete(this.blocks, email)
+			}
+		}
+	}
+	// 解除到期的封禁
+	for email, blk := range this.blocks {
+		if now.Before(blk.until) {
+			continue
+		}
+		delete(this.blocks, email)
+		this.frees[email] = now
+		if err := serve.DelRoute0(IpLimitTag(email)); err != nil {
+			fmt.Printf("解除IP限制失败: %s, %s\n", email, err.Error())
+		}
+		events.Emit("iplimit.release", "解除IP限制: "+email, blk)
+	}
+	for email, free := range this.frees {
+		if now.Sub(free) > IpLimitSeen {
+			delete(this.frees, email)
+		}
+	}
+	// 收集入站用户
+	emails := map[string]bool{}
+	tags, _ := serve.LstInbound0()
+	for _, tag := range tags {
+		users, err := serve.InboundUsers(tag.(string))
+		if err != nil {
+			continue
+		}
+		for _, user := range users {
+			if user.Email != "" {
+				emails[user.Email] = true
+			}
+		}
+	}
+	for email := range this.first {
+		if !emails[email] {
+			delete(this.first, email)
+		}
+	}
+	for email := range emails {
+		limit := this.GetLimit(email)
+		omp := mng.GetOnlineMap("user>>>" + email + ">>>online")
+		if limit <= 0 || omp == nil {
+			delete(this.first, email)
+			continue
+		}
+		// 记录IP首次出现时间, 先到先得
+		seen, ok := this.first[email]
+		if !ok {
+			seen = map[string]time.Time{}
+			this.first[email] = seen
+		}
+		// 按最后出现时间判断在线, 在线IP过期前仍在列表中, 解除封禁前出现的IP也不计数
+		after := now.Add(-IpLimitSeen)
+		if free, ok := this.frees[email]; ok && free.After(after) {
+			after = free
+		}
+		online := map[string]bool{}
+		for ip, last := range omp.IpTimeMap() {
+			if !last.After(after) {
+				continue
+			}
+			online[ip] = true
+			if _, ok := seen[ip]; !ok {
+				seen[ip] = now
+			}
+		}
+		for ip := range seen {
+			if !online[ip] {
+				delete(seen, ip)
+			}
+		}
+		if len(seen) <= limit {
+			continue
+		}
+		ips := make([]string, 0, len(seen))
+		for ip := range seen {
+			ips = append(ips, ip)
+		}
+		sort.Slice(ips, func(i, j int) bool {
+			ti, tj := seen[ips[i]], seen[ips[j]]
+			return ti.Before(tj) || ti.Equal(tj) && ips[i] < ips[j]
+		})
+		this.block(serve, events, email, ips[limit:], now)
+	}
+}
+
+/**
+ * 封禁用户IP, 已封禁时合并新的IP
+ */
+func (this *IpLimiter) block(serve *XrayServe, events *Events, email string, ips []string, now time.Time) {
+	blk, ok := this.blocks[email]
+	if ok {
+		known := map[string]bool{}
+		for _, ip := range blk.Ips {
+			known[ip] = true
+		}
+		added := false
+		for _, ip := range ips {
+			if !known[ip] {
+				blk.Ips = append(blk.Ips, ip)
+				added = true
+			}
+		}
+		if !added {
+			return
+		}
+		serve.DelRoute0(IpLimitTag(email))
+	} else {
+		until := now.Add(this.Cooldown)
+		blk = &IpBlock{Email: email, Ips: ips, Until: until.Format(time.RFC3339), until: until}
+	}
+	if err := this.route(serve, blk); err != nil {
+		fmt.Printf("添加IP限制失败: %s, %s\n", email, err.Error())
+		delete(this.blocks, email)
+		return
+	}
+	this.blocks[email] = blk
+	events.Emit("iplimit.block", fmt.Sprintf("超出IP限制: %s, 封禁: %v", email, blk.Ips), blk)
+}
+
+/**
+ * 添加封禁路由, 并调整到最前
+ */
+func (this *IpLimiter) route(serve *XrayServe, blk *IpBlock) error {
+	rule, _ := json.Marshal(map[string]any{
+		"ruleTag":     IpLimitTag(blk.Email),
+		"user":        []string{blk.Email},
+		"source":      blk.Ips,
+		"outboundTag": this.Outbound,
+	})
+	if err := serve.AddRoute(rule, false); err != nil {
+		return err
+	}
+	if err := serve.TopRoute0(IpLimitTag(blk.Email)); err != nil {
+		fmt.Printf("调整IP限制顺序失败: %s, %s\n", blk.Email, err.Error())
+	}
+	return nil
+}
+
+/**
+ * 限制路由的 tag
+ */
+func IpLimitTag(email string) string {
+	return "iplimit>>>" + email
+}
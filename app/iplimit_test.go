@@ -0,0 +1,100 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/stats"
+)
+
+/**
+ * 启用在线统计的 Xray, 一个 vless 用户 u@in
+ */
+func testOnlineXray(t *testing.T) *XrayServe {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "xray.json")
+	text := fmt.Sprintf(`{"stats": {}, "policy": {"levels": {"0": {"statsUserOnline": true}}}, "routing": {"rules": []},
+		"inbounds": [{"tag": "in", "listen": "127.0.0.1", "port": %d, "protocol": "vless",
+			"settings": {"clients": [{"id": %q, "email": "u@in"}], "decryption": "none"}}],
+		"outbounds": [{"protocol": "freedom", "tag": "direct"}, {"protocol": "blackhole", "tag": "blocked"}]}`, freePort(t), testUuid)
+	if err := os.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	serve := &XrayServe{Xrayc: file}
+	if msg := serve.StartXray(); msg != "" {
+		t.Fatal(msg)
+	}
+	t.Cleanup(func() { serve.Instance().Close() })
+	return serve
+}
+
+func testOnlineIps(t *testing.T, serve *XrayServe, ips ...string) {
+	t.Helper()
+	mng, err := serve.StatsManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	omp, err := mng.RegisterOnlineMap("user>>>u@in>>>online")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range ips {
+		omp.(*stats.OnlineMap).AddIP(ip)
+	}
+}
+
+func testHasRoute(serve *XrayServe, tag string) bool {
+	rules, _ := serve.LstRoute0()
+	for _, rule := range rules {
+		if rule == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestIpLimitReload(t *testing.T) {
+	serve := testOnlineXray(t)
+	limiter := &IpLimiter{Limit: 1, Cooldown: time.Minute, Outbound: "blocked"}
+	events := &Events{}
+	testOnlineIps(t, serve, "10.0.0.1", "10.0.0.2")
+	now := time.Now()
+	limiter.Check(serve, events, now)
+	if blk := limiter.blocks["u@in"]; blk == nil || len(blk.Ips) != 1 || blk.Ips[0] != "10.0.0.2" {
+		t.Fatalf("blocks: %#v", limiter.blocks)
+	}
+	if !testHasRoute(serve, IpLimitTag("u@in")) {
+		t.Fatal("block route missing")
+	}
+	// 重新加载后路由丢失, 检测时重新添加
+	if msg := serve.RestartXray(true); msg != "" {
+		t.Fatal(msg)
+	}
+	if testHasRoute(serve, IpLimitTag("u@in")) {
+		t.Fatal("route survived reload")
+	}
+	limiter.Check(serve, events, now.Add(time.Second))
+	if !testHasRoute(serve, IpLimitTag("u@in")) {
+		t.Fatal("block route not re-applied after reload")
+	}
+	// 到期解除后, 解除前出现的IP不计数, 不会立即重新封禁
+	testOnlineIps(t, serve, "10.0.0.1", "10.0.0.2")
+	limiter.Check(serve, events, now.Add(2*time.Minute))
+	if len(limiter.blocks) != 0 || testHasRoute(serve, IpLimitTag("u@in")) {
+		t.Fatalf("re-blocked after release: %#v", limiter.blocks)
+	}
+}
+
+func TestIpLimitLastSeen(t *testing.T) {
+	serve := testOnlineXray(t)
+	limiter := &IpLimiter{Limit: 1, Cooldown: time.Minute, Outbound: "blocked"}
+	testOnlineIps(t, serve, "10.0.0.1", "10.0.0.2")
+	// 超过有效时长未出现的IP不计数
+	limiter.Check(serve, &Events{}, time.Now().Add(IpLimitSeen+time.Second))
+	if len(limiter.blocks) != 0 {
+		t.Fatalf("blocked stale ips: %#v", limiter.blocks)
+	}
+}
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * JWT Bearer 认证, 支持 RS256, ES256, EdDSA
+ * 公钥来自 PEM 文件或本地 JWKS 文件, 文件修改后自动重新加载
+ * 权限来自 claims: scope (空格分隔) 或 scopes (数组), 格式同令牌权限
+ * 以及 actions (数组, 转为 action:) 和 tags (数组, 转为 tag:)
+ */
+type JwtVerifier struct {
+	KeyFile  string // PEM 公钥文件
+	JwksFile string // JWKS 文件
+	Issuer   string // 为空不校验
+	Audience string // 为空不校验
+
+	lock  sync.Mutex
+	keys  map[string]crypto.PublicKey // kid -> 公钥, PEM 公钥的 kid 为空
+	mtime time.Time
+}
+
+/**
+ * JWT claims
+ */
+type JwtClaims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  any      `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+	Scope     string   `json:"scope"`
+	Scopes    []string `json:"scopes"`
+	Actions   []string `json:"actions"`
+	Tags      []string `json:"tags"`
+}
+
+func (this *JwtVerifier) Enabled() bool {
+	return this.KeyFile != "" || this.JwksFile != ""
+}
+
+/**
+ * 检查配置, PEM 公钥和 JWKS 只能配置一个
+ */
+func (this *JwtVerifier) Check() error {
+	if this.KeyFile != "" && this.JwksFile != "" {
+		return errors.New("-jwt-key 和 -jwt-jwks 不能同时配置")
+	}
+	return nil
+}
+
+/**
+ * 加载公钥, 文件未修改时跳过
+ */
+func (this *JwtVerifier) load() error {
+	file := this.KeyFile
+	if file == "" {
+		file = this.JwksFile
+	}
+	stat, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if this.keys != nil && stat.ModTime().Equal(this.mtime) {
+		return nil
+	}
+	bts, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	keys := map[string]crypto.PublicKey{}
+	if this.KeyFile != "" {
+		block, _ := pem.Decode(bts)
+		if block == nil {
+			return errors.New("无效的 PEM 公钥")
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		keys[""] = key
+	} else {
+		jwks := struct {
+			Keys []map[string]string `json:"keys"`
+		}{}
+		if err := json.Unmarshal(bts, &jwks); err != nil {
+			return errors.New("无效的 JWKS: " + err.Error())
+		}
+		for _, jwk := range jwks.Keys {
+			key, err := ParseJwk(jwk)
+			if err != nil {
+				fmt.Printf("跳过 JWK: %s, %s\n", jwk["kid"], err.Error())
+				continue
+			}
+			keys[jwk["kid"]] = key
+		}
+	}
+	this.keys, this.mtime = keys, stat.ModTime()
+	return nil
+}
+
+/**
+ * 解析 JWK 公钥, 支持 RSA, EC P-256, OKP Ed25519
+ */
+func ParseJwk(jwk map[string]string) (crypto.PublicKey, error) {
+	decode := func(key string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk[key], "="))
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		nn, err1 := decode("n")
+		ee, err2 := decode("e")
+		if err1 != nil || err2 != nil || len(nn) == 0 || len(ee) == 0 {
+			return nil, errors.New("无效的 RSA 公钥")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(nn), E: int(new(big.Int).SetBytes(ee).Int64())}, nil
+	case "EC":
+		if jwk["crv"] != "P-256" {
+			return nil, errors.New("不支持的曲线: " + jwk["crv"])
+		}
+		xx, err1 := decode("x")
+		yy, err2 := decode("y")
+		if err1 != nil || err2 != nil {
+			return nil, errors.New("无效的 EC 公钥")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(xx), Y: new(big.Int).SetBytes(yy)}
+		if _, err := key.ECDH(); err != nil {
+			return nil, errors.New("无效的 EC 公钥")
+		}
+		return key, nil
+	case "OKP":
+		xx, err := decode("x")
+		if jwk["crv"] != "Ed25519" || err != nil || len(xx) != ed25519.PublicKeySize {
+			return nil, errors.New("无效的 Ed25519 公钥")
+		}
+		return ed25519.PublicKey(xx), nil
+	}
+	return nil, errors.New("不支持的密钥类型: " + jwk["kty"])
+}
+
+/**
+ * 验证 JWT, 返回 claims
+ */
+func (this *JwtVerifier) Verify(token string, now time.Time) (*JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("无效的 JWT")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if bts, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil {
+		return nil, errors.New("无效的 JWT header")
+	} else if err := json.Unmarshal(bts, &header); err != nil {
+		return nil, errors.New("无效的 JWT header")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("无效的 JWT 签名")
+	}
+	// 公钥
+	this.lock.Lock()
+	if err := this.load(); err != nil {
+		this.lock.Unlock()
+		return nil, errors.New("加载公钥失败: " + err.Error())
+	}
+	key, ok := this.keys[header.Kid]
+	if !ok && this.KeyFile != "" {
+		key, ok = this.keys[""], true
+	}
+	this.lock.Unlock()
+	if !ok {
+		return nil, errors.New("未知的 kid: " + header.Kid)
+	}
+	// 签名, 算法必须与公钥类型匹配
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	valid := false
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		valid = header.Alg == "RS256" && rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig) == nil
+	case *ecdsa.PublicKey:
+		if header.Alg == "ES256" && len(sig) == 64 {
+			rr, ss := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			valid = ecdsa.Verify(pub, hash[:], rr, ss)
+		}
+	case ed25519.PublicKey:
+		valid = header.Alg == "EdDSA" && ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig)
+	default:
+		return nil, errors.New("不支持的公钥类型")
+	}
+	if !valid {
+		return nil, errors.New("无效的 JWT 签名")
+	}
+	// claims
+	claims := &JwtClaims{}
+	if bts, err := base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return nil, errors.New("无效的 JWT claims")
+	} else if err := json.Unmarshal(bts, claims); err != nil {
+		return nil, errors.New("无效的 JWT claims")
+	}
+	if claims.ExpiresAt == nil || now.Unix() >= *claims.ExpiresAt {
+		return nil, errors.New("JWT 已过期")
+	}
+	if claims.NotBefore != nil && now.Unix() < *claims.NotBefore {
+		return nil, errors.New("JWT 未生效")
+	}
+	if this.Issuer != "" && claims.Issuer != this.Issuer {
+		return nil, errors.New("无效的 iss")
+	}
+	if this.Audience != "" && !claims.HasAudience(this.Audience) {
+		return nil, errors.New("无效的 aud")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("缺少 sub")
+	}
+	return claims, nil
+}
+
+func (this *JwtClaims) HasAudience(aud string) bool {
+	switch val := this.Audience.(type) {
+	case string:
+		return val == aud
+	case []any:
+		for _, item := range val {
+			if item == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**
+ * claims 转为权限, 无效的权限忽略
+ */
+func (this *JwtClaims) ToScopes() []string {
+	scopes := append(strings.Fields(this.Scope), this.Scopes...)
+	for _, action := range this.Actions {
+		scopes = append(scopes, "action:"+action)
+	}
+	for _, tag := range this.Tags {
+		scopes = append(scopes, "tag:"+tag)
+	}
+	data := []string{}
+	for _, scope := range scopes {
+		if CheckScope(scope) == nil {
+			data = append(data, scope)
+		}
+	}
+	return data
+}
@@ -0,0 +1,158 @@
+package app
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * 签发 JWT, alg 与 key 可以不匹配, 用于测试
+ */
+func jwtSign(t *testing.T, alg, kid string, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+	head, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	text := base64.RawURLEncoding.EncodeToString(head) + "." + base64.RawURLEncoding.EncodeToString(body)
+	hash := sha256.Sum256([]byte(text))
+	var sig []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		rr, ss, err2 := ecdsa.Sign(rand.Reader, key, hash[:])
+		sig, err = append(rr.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...), err2
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, []byte(text))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwtPemFile(t *testing.T, file string, pub crypto.PublicKey) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJwtVerify(t *testing.T) {
+	dir := t.TempDir()
+	rsk, _ := rsa.GenerateKey(rand.Reader, 2048)
+	eck, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edk, _ := ed25519.GenerateKey(rand.Reader)
+	// JWKS 包含三种公钥
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwks, _ := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "n": b64(rsk.N.Bytes()), "e": b64([]byte{1, 0, 1})},
+		{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(eck.X.FillBytes(make([]byte, 32))), "y": b64(eck.Y.FillBytes(make([]byte, 32)))},
+		{"kid": "ed", "kty": "OKP", "crv": "Ed25519", "x": b64(edk.Public().(ed25519.PublicKey))},
+	}})
+	file := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(file, jwks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	verifier := &JwtVerifier{JwksFile: file, Issuer: "iss", Audience: "xrayw"}
+	now := time.Now()
+	valid := func() map[string]any {
+		return map[string]any{"sub": "user", "iss": "iss", "aud": []string{"other", "xrayw"}, "exp": now.Unix() + 60, "scope": "read"}
+	}
+	with := func(key string, val any) map[string]any {
+		claims := valid()
+		if val == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = val
+		}
+		return claims
+	}
+	cases := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"rs256", jwtSign(t, "RS256", "rsa", rsk, valid()), ""},
+		{"es256", jwtSign(t, "ES256", "ec", eck, valid()), ""},
+		{"eddsa", jwtSign(t, "EdDSA", "ed", edk, valid()), ""},
+		{"alg mismatch", jwtSign(t, "ES256", "rsa", rsk, valid()), "无效的 JWT 签名"},
+		{"alg none", jwtSign(t, "none", "ed", edk, valid()), "无效的 JWT 签名"},
+		{"key type mismatch", jwtSign(t, "EdDSA", "ec", edk, valid()), "无效的 JWT 签名"},
+		{"unknown kid", jwtSign(t, "EdDSA", "xx", edk, valid()), "未知的 kid"},
+		{"missing exp", jwtSign(t, "EdDSA", "ed", edk, with("exp", nil)), "JWT 已过期"},
+		{"expired", jwtSign(t, "EdDSA", "ed", edk, with("exp", now.Unix())), "JWT 已过期"},
+		{"not before", jwtSign(t, "EdDSA", "ed", edk, with("nbf", now.Unix()+60)), "JWT 未生效"},
+		{"missing sub", jwtSign(t, "EdDSA", "ed", edk, with("sub", nil)), "缺少 sub"},
+		{"wrong iss", jwtSign(t, "EdDSA", "ed", edk, with("iss", "other")), "无效的 iss"},
+		{"wrong aud", jwtSign(t, "EdDSA", "ed", edk, with("aud", "other")), "无效的 aud"},
+		{"malformed", "a.b", "无效的 JWT"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := verifier.Verify(tc.token, now)
+			if tc.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if claims.Subject != "user" || strings.Join(claims.ToScopes(), ",") != "read" {
+					t.Fatalf("claims: %#v", claims)
+				}
+			} else if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Fatalf("error: %v", err)
+			}
+		})
+	}
+}
+
+func TestJwtReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "jwt.pem")
+	_, old, _ := ed25519.GenerateKey(rand.Reader)
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	jwtPemFile(t, file, old.Public())
+	verifier := &JwtVerifier{KeyFile: file}
+	now := time.Now()
+	claims := map[string]any{"sub": "user", "exp": now.Unix() + 60}
+	if _, err := verifier.Verify(jwtSign(t, "EdDSA", "", old, claims), now); err != nil {
+		t.Fatal(err)
+	}
+	// 替换公钥, 修改时间变化后重新加载
+	jwtPemFile(t, file, key.Public())
+	mtime := now.Add(time.Minute)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifier.Verify(jwtSign(t, "EdDSA", "", old, claims), now); err == nil {
+		t.Fatal("old key accepted after reload")
+	}
+	if _, err := verifier.Verify(jwtSign(t, "EdDSA", "", key, claims), now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJwtCheck(t *testing.T) {
+	if err := (&JwtVerifier{KeyFile: "a.pem", JwksFile: "a.json"}).Check(); err == nil {
+		t.Fatal("both -jwt-key and -jwt-jwks accepted")
+	}
+	if err := (&JwtVerifier{JwksFile: "a.json"}).Check(); err != nil {
+		t.Fatal(err)
+	}
+}
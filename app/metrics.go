@@ -0,0 +1,179 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	feature_stats "github.com/xtls/xray-core/features/stats"
+)
+
+/**
+ * 请求耗时分布(秒)
+ */
+var MetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+/**
+ * 请求统计
+ */
+type ApiMetric struct {
+	Count   int64
+	Sum     float64
+	Buckets []int64
+}
+
+/**
+ * Prometheus 指标
+ */
+type Metrics struct {
+	lock sync.Mutex
+	reqs map[[2]string]int64   // action, errcode -> count
+	lats map[string]*ApiMetric // action -> latency
+}
+
+/**
+ * 记录响应结果的 ResponseWriter
+ */
+type ResultWriter struct {
+	http.ResponseWriter
+	Result *Result
+	Action string // 通过认证并分发到处理函数的 action, 用于指标标签
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 记录请求
+ */
+func (this *Metrics) Observe(action, errcode string, cost time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.reqs == nil {
+		this.reqs = map[[2]string]int64{}
+		this.lats = map[string]*ApiMetric{}
+	}
+	this.reqs[[2]string{action, errcode}]++
+	lat, ok := this.lats[action]
+	if !ok {
+		lat = &ApiMetric{Buckets: make([]int64, len(MetricsBuckets))}
+		this.lats[action] = lat
+	}
+	sec := cost.Seconds()
+	lat.Count++
+	lat.Sum += sec
+	for idx, le := range MetricsBuckets {
+		if sec <= le {
+			lat.Buckets[idx]++
+		}
+	}
+}
+
+/**
+ * 输出请求指标
+ */
+func (this *Metrics) Write(ww io.Writer) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	fmt.Fprintln(ww, "# HELP xrayw_api_requests_total Total API requests by action and errcode.")
+	fmt.Fprintln(ww, "# TYPE xrayw_api_requests_total counter")
+	keys := make([][2]string, 0, len(this.reqs))
+	for key := range this.reqs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(ww, "xrayw_api_requests_total{action=\"%s\",errcode=\"%s\"} %d\n", MetricsLabel(key[0]), MetricsLabel(key[1]), this.reqs[key])
+	}
+
+	fmt.Fprintln(ww, "# HELP xrayw_api_request_duration_seconds API request latency by action.")
+	fmt.Fprintln(ww, "# TYPE xrayw_api_request_duration_seconds histogram")
+	acts := make([]string, 0, len(this.lats))
+	for act := range this.lats {
+		acts = append(acts, act)
+	}
+	sort.Strings(acts)
+	for _, act := range acts {
+		lat, lbl := this.lats[act], MetricsLabel(act)
+		for idx, le := range MetricsBuckets {
+			fmt.Fprintf(ww, "xrayw_api_request_duration_seconds_bucket{action=\"%s\",le=\"%g\"} %d\n", lbl, le, lat.Buckets[idx])
+		}
+		fmt.Fprintf(ww, "xrayw_api_request_duration_seconds_bucket{action=\"%s\",le=\"+Inf\"} %d\n", lbl, lat.Count)
+		fmt.Fprintf(ww, "xrayw_api_request_duration_seconds_sum{action=\"%s\"} %g\n", lbl, lat.Sum)
+		fmt.Fprintf(ww, "xrayw_api_request_duration_seconds_count{action=\"%s\"} %d\n", lbl, lat.Count)
+	}
+}
+
+/**
+ * 转义标签值
+ */
+func MetricsLabel(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(val)
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 输出 Xray 指标
+ */
+func (this *XrayServe) WriteMetrics(ww io.Writer) {
+	running := 0
+	if this.IsRunning() {
+		running = 1
+	}
+	fmt.Fprintln(ww, "# HELP xray_running Whether the Xray instance is running.")
+	fmt.Fprintln(ww, "# TYPE xray_running gauge")
+	fmt.Fprintf(ww, "xray_running %d\n", running)
+	if running == 0 {
+		return
+	}
+	uptime := 0.0
+	if start := this.Started(); start != nil {
+		uptime = time.Since(*start).Seconds()
+	}
+	fmt.Fprintln(ww, "# HELP xray_uptime_seconds Seconds since the Xray instance was started.")
+	fmt.Fprintln(ww, "# TYPE xray_uptime_seconds gauge")
+	fmt.Fprintf(ww, "xray_uptime_seconds %g\n", uptime)
+
+	inbs, _ := this.LstInbound0()
+	otbs, _ := this.LstOutbound0()
+	ruls, _ := this.LstRoute0()
+	fmt.Fprintln(ww, "# HELP xray_inbounds Number of inbound handlers.")
+	fmt.Fprintln(ww, "# TYPE xray_inbounds gauge")
+	fmt.Fprintf(ww, "xray_inbounds %d\n", len(inbs))
+	fmt.Fprintln(ww, "# HELP xray_outbounds Number of outbound handlers.")
+	fmt.Fprintln(ww, "# TYPE xray_outbounds gauge")
+	fmt.Fprintf(ww, "xray_outbounds %d\n", len(otbs))
+	fmt.Fprintln(ww, "# HELP xray_rules Number of routing rules.")
+	fmt.Fprintln(ww, "# TYPE xray_rules gauge")
+	fmt.Fprintf(ww, "xray_rules %d\n", len(ruls))
+
+	mng, err := this.StatsManager()
+	if err != nil {
+		return
+	}
+	lines := []string{}
+	mng.VisitCounters(func(name string, ctr feature_stats.Counter) bool {
+		// inbound>>>tag>>>traffic>>>uplink
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" {
+			return true
+		}
+		line := fmt.Sprintf("xray_traffic_bytes_total{kind=\"%s\",name=\"%s\",direction=\"%s\"} %d",
+			MetricsLabel(parts[0]), MetricsLabel(parts[1]), MetricsLabel(parts[3]), ctr.Value())
+		lines = append(lines, line)
+		return true
+	})
+	sort.Strings(lines)
+	fmt.Fprintln(ww, "# HELP xray_traffic_bytes_total Xray traffic counters by inbound, outbound and user.")
+	fmt.Fprintln(ww, "# TYPE xray_traffic_bytes_total counter")
+	for _, line := range lines {
+		fmt.Fprintln(ww, line)
+	}
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/proxy"
+)
+
+/**
+ * 在线IP
+ */
+type OnlineIp struct {
+	Ip   string `json:"ip"`
+	Last string `json:"last"`
+}
+
+/**
+ * 在线用户
+ */
+type OnlineUser struct {
+	Email  string `json:"email"`
+	Online int    `json:"online"`
+}
+
+/**
+ * 入站在线情况
+ */
+type OnlineInbound struct {
+	Tag    string        `json:"tag"`
+	Users  int           `json:"users"`
+	Online int           `json:"online"`
+	List   []*OnlineUser `json:"list"`
+}
+
+/**
+ * 入站及在线用户数量
+ */
+type InboundOnline struct {
+	Tag    string `json:"tag"`
+	Online int    `json:"online"`
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 获取入站用户, 需要入站协议支持用户管理
+ */
+func (this *XrayServe) InboundUsers(tag string) ([]*protocol.MemoryUser, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if !this.isRunning() {
+		return nil, errors.New("Xray未启动")
+	}
+	ctx := context.TODO()
+	mng := this.XrayA.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	hdl, err := mng.GetHandler(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	gib, ok := hdl.(proxy.GetInbound)
+	if !ok {
+		return nil, errors.New("inbound 不支持获取代理: " + tag)
+	}
+	umg, ok := gib.GetInbound().(proxy.UserManager)
+	if !ok {
+		return nil, errors.New("inbound 不支持用户管理: " + tag)
+	}
+	return umg.GetUsers(ctx), nil
+}
+
+/**
+ * 获取用户在线IP, 需要在 policy 中启用 statsUserOnline
+ */
+func (this *XrayServe) GetOnlineIps(email string) ([]*OnlineIp, error) {
+	mng, err := this.StatsManager()
+	if err != nil {
+		return nil, err
+	}
+	data := []*OnlineIp{}
+	omp := mng.GetOnlineMap("user>>>" + email + ">>>online")
+	if omp == nil {
+		return data, nil
+	}
+	for ip, last := range omp.IpTimeMap() {
+		data = append(data, &OnlineIp{Ip: ip, Last: last.Format(time.RFC3339)})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Ip < data[j].Ip })
+	return data, nil
+}
+
+/**
+ * 获取用户在线IP数量
+ */
+func (this *XrayServe) GetOnlineCount(email string) int {
+	mng, err := this.StatsManager()
+	if err != nil {
+		return 0
+	}
+	omp := mng.GetOnlineMap("user>>>" + email + ">>>online")
+	if omp == nil {
+		return 0
+	}
+	return omp.Count()
+}
+
+/**
+ * 是否在 policy 中启用了 statsUserOnline
+ */
+func (this *XrayServe) UserOnline() bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.Xconf == nil || this.Xconf.Policy == nil {
+		return false
+	}
+	for _, plc := range this.Xconf.Policy.Levels {
+		if plc != nil && plc.StatsUserOnline {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 列出入站, 启用 statsUserOnline 时返回 {tag, online}, online 为在线用户数量, 否则只返回 tag
+ */
+func (this *XrayServe) LstInboundOnline() ([]any, error) {
+	data, err := this.LstInbound0()
+	if err != nil || !this.UserOnline() {
+		return data, err
+	}
+	for idx, itm := range data {
+		item := &InboundOnline{Tag: itm.(string)}
+		users, _ := this.InboundUsers(item.Tag) // 不支持用户管理的入站 online 为 0
+		for _, user := range users {
+			if user.Email != "" && this.GetOnlineCount(user.Email) > 0 {
+				item.Online++
+			}
+		}
+		data[idx] = item
+	}
+	return data, nil
+}
+
+/**
+ * 列出入站在线用户, tag 为空列出全部入站
+ */
+func (this *XrayServe) LstOnline(tag string) ([]*OnlineInbound, error) {
+	tags, single := []string{tag}, tag != ""
+	if !single {
+		tags = []string{}
+		data, err := this.LstInbound0()
+		if err != nil {
+			return nil, err
+		}
+		for _, itm := range data {
+			tags = append(tags, itm.(string))
+		}
+	}
+	data := []*OnlineInbound{}
+	for _, tag := range tags {
+		users, err := this.InboundUsers(tag)
+		if err != nil {
+			if single {
+				return nil, err
+			}
+			continue // 跳过不支持用户管理的入站
+		}
+		item := &OnlineInbound{Tag: tag, Users: len(users), List: []*OnlineUser{}}
+		for _, user := range users {
+			if user.Email == "" {
+				continue
+			}
+			if count := this.GetOnlineCount(user.Email); count > 0 {
+				item.Online++
+				item.List = append(item.List, &OnlineUser{Email: user.Email, Online: count})
+			}
+		}
+		data = append(data, item)
+	}
+	return data, nil
+}
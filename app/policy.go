@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/xtls/xray-core/app/policy"
+	"github.com/xtls/xray-core/infra/conf"
+
+	feature_policy "github.com/xtls/xray-core/features/policy"
+)
+
+/**
+ * 列出策略
+ */
+func (this *XrayServe) LstPolicy() (*conf.PolicyConfig, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.Xconf == nil {
+		return nil, errors.New("未初始化配置文件")
+	}
+	return this.copyPolicy(), nil
+}
+
+/**
+ * 创建或修改用户等级策略
+ */
+func (this *XrayServe) SetPolicy(level uint32, plc *conf.Policy) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	cplc := this.copyPolicy()
+	cplc.Levels[level] = plc
+	return this.applyPolicy(cplc)
+}
+
+/**
+ * 删除用户等级策略, 使用该等级的用户恢复默认策略
+ */
+func (this *XrayServe) DelPolicy(level uint32) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	cplc := this.copyPolicy()
+	if _, ok := cplc.Levels[level]; !ok {
+		return fmt.Errorf("policy 未找到: %d", level)
+	}
+	delete(cplc.Levels, level)
+	return this.applyPolicy(cplc)
+}
+
+/**
+ * 修改系统策略, 统计开关只对之后创建的 inbound/outbound 生效
+ */
+func (this *XrayServe) SetSysPolicy(plc *conf.SystemPolicy) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	cplc := this.copyPolicy()
+	cplc.System = plc
+	return this.applyPolicy(cplc)
+}
+
+func (this *XrayServe) copyPolicy() *conf.PolicyConfig {
+	cplc := &conf.PolicyConfig{Levels: map[uint32]*conf.Policy{}}
+	if this.Xconf.Policy != nil {
+		for lvl, plc := range this.Xconf.Policy.Levels {
+			cplc.Levels[lvl] = plc
+		}
+		cplc.System = this.Xconf.Policy.System
+	}
+	return cplc
+}
+
+/**
+ * 应用策略到运行中的策略管理器, 新的连接生效, 不中断现有连接
+ * policy.Instance 没有锁, 原子替换 levels 和 system 字段, 读取方只会看到完整的旧值或新值
+ */
+func (this *XrayServe) applyPolicy(cplc *conf.PolicyConfig) error {
+	pcfg, err := cplc.Build()
+	if err != nil {
+		fmt.Printf("policy 转换配置文件失败: %s\n", err.Error())
+		return err
+	}
+	if this.isRunning() {
+		mng, ok := this.XrayA.GetFeature(feature_policy.ManagerType()).(*policy.Instance)
+		if !ok {
+			// 配置文件中没有 policy 时使用默认策略管理器, 无法修改
+			return errors.New("运行中的 Xray 未配置 policy, 请在配置文件中添加 policy 后重启")
+		}
+		inst, err := policy.New(context.TODO(), pcfg)
+		if err != nil {
+			fmt.Printf("policy 创建失败: %s\n", err.Error())
+			return err
+		}
+		dst, src := reflect.ValueOf(mng).Elem(), reflect.ValueOf(inst).Elem()
+		for _, name := range []string{"levels", "system"} {
+			// map 和指针字段都是单个指针
+			ptr := (*unsafe.Pointer)(unsafe.Pointer(dst.FieldByName(name).UnsafeAddr()))
+			atomic.StorePointer(ptr, *(*unsafe.Pointer)(unsafe.Pointer(src.FieldByName(name).UnsafeAddr())))
+		}
+	}
+	this.Xconf.Policy = cplc
+	return nil
+}
@@ -0,0 +1,41 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+
+	feature_policy "github.com/xtls/xray-core/features/policy"
+)
+
+func TestPolicyLive(t *testing.T) {
+	serve := testXray(t)
+	inst := serve.XrayA
+	mng := inst.GetFeature(feature_policy.ManagerType()).(feature_policy.Manager)
+	idle := uint32(7)
+	if err := serve.SetPolicy(3, &conf.Policy{ConnectionIdle: &idle}); err != nil {
+		t.Fatal(err)
+	}
+	if serve.XrayA != inst || !serve.IsRunning() {
+		t.Fatal("instance replaced")
+	}
+	if got := mng.ForLevel(3).Timeouts.ConnectionIdle; got != 7*time.Second {
+		t.Fatalf("idle: %v", got)
+	}
+	if err := serve.SetSysPolicy(&conf.SystemPolicy{StatsInboundUplink: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !mng.ForSystem().Stats.InboundUplink || mng.ForLevel(3).Timeouts.ConnectionIdle != 7*time.Second {
+		t.Fatal("system policy not applied")
+	}
+	if err := serve.DelPolicy(3); err != nil {
+		t.Fatal(err)
+	}
+	if got := mng.ForLevel(3).Timeouts.ConnectionIdle; got != feature_policy.SessionDefault().Timeouts.ConnectionIdle {
+		t.Fatalf("idle after delete: %v", got)
+	}
+	if err := serve.DelPolicy(3); err == nil {
+		t.Fatal("deleted missing level")
+	}
+}
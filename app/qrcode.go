@@ -0,0 +1,80 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/skip2/go-qrcode"
+)
+
+/**
+ * 二维码参数, format=png|svg, size 为像素, level 为纠错等级
+ */
+type QrOption struct {
+	Format string
+	Size   int
+	Level  qrcode.RecoveryLevel
+}
+
+/**
+ * 解析二维码参数, level=L|M|Q|H, 默认值 png, 256, M
+ */
+func NewQrOption(format, size, level string) (*QrOption, error) {
+	opt := &QrOption{Format: format, Size: 256, Level: qrcode.Medium}
+	if opt.Format == "" {
+		opt.Format = "png"
+	}
+	if opt.Format != "png" && opt.Format != "svg" {
+		return nil, errors.New("无效的 format: " + format)
+	}
+	if size != "" {
+		val, err := strconv.Atoi(size)
+		if err != nil || val < 64 || val > 2048 {
+			return nil, errors.New("无效的 size, 范围 64-2048")
+		}
+		opt.Size = val
+	}
+	switch level {
+	case "L", "l":
+		opt.Level = qrcode.Low
+	case "", "M", "m":
+		opt.Level = qrcode.Medium
+	case "Q", "q":
+		opt.Level = qrcode.High
+	case "H", "h":
+		opt.Level = qrcode.Highest
+	default:
+		return nil, errors.New("无效的 level: " + level)
+	}
+	return opt, nil
+}
+
+/**
+ * 生成二维码, 返回内容和 Content-Type
+ */
+func (this *QrOption) Encode(content string) ([]byte, string, error) {
+	code, err := qrcode.New(content, this.Level)
+	if err != nil {
+		return nil, "", err
+	}
+	if this.Format == "png" {
+		bts, err := code.PNG(this.Size)
+		return bts, "image/png", err
+	}
+	// SVG, 每个模块一个单位, 由 viewBox 缩放
+	bitmap := code.Bitmap()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, this.Size, this.Size, len(bitmap), len(bitmap))
+	fmt.Fprintf(buf, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(buf, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes(), "image/svg+xml", nil
+}
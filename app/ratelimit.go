@@ -0,0 +1,264 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 限流规则, 格式: pattern=count/duration, 例如 xray.app.proxyman.conf.Add*=10/1m
+ * pattern 为 action 的 path.Match 通配符, 匹配的 action 属于同一族, 共用令牌桶
+ */
+type RateRule struct {
+	Pattern string
+	Count   int
+	Period  time.Duration
+}
+
+/**
+ * 令牌桶
+ */
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+	period time.Duration
+}
+
+/**
+ * 认证失败记录
+ */
+type authFail struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+/**
+ * API 限流和暴力破解防护
+ * 按客户端 IP 和认证主体分别限流, 按 action 族配置
+ * 连续 invalid_token 达到 Fails 次后锁定客户端 IP, 锁定时长从 Lockout 开始指数增长, 最长 MaxLock
+ */
+type RateLimiter struct {
+	IpRules    []*RateRule   // 按客户端 IP 限流
+	TokenRules []*RateRule   // 按认证主体限流
+	Fails      int           // 锁定前允许的失败次数, 0 不锁定
+	Lockout    time.Duration // 首次锁定时长
+	MaxLock    time.Duration // 最长锁定时长
+
+	lock    sync.Mutex
+	buckets map[string]*rateBucket
+	fails   map[string]*authFail
+}
+
+/**
+ * 解析限流规则, 逗号分隔, 先匹配的规则生效
+ */
+func ParseRateRules(text string) ([]*RateRule, error) {
+	rules := []*RateRule{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		pattern, rate, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, errors.New("无效的限流规则: " + item)
+		}
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, errors.New("无效的限流规则: " + item)
+		}
+		num, dur, _ := strings.Cut(rate, "/")
+		count, err := strconv.Atoi(num)
+		if err != nil || count <= 0 {
+			return nil, errors.New("无效的限流次数: " + item)
+		}
+		period := time.Second
+		if dur != "" {
+			if period, err = time.ParseDuration(dur); err != nil || period <= 0 {
+				return nil, errors.New("无效的限流周期: " + item)
+			}
+		}
+		rules = append(rules, &RateRule{Pattern: pattern, Count: count, Period: period})
+	}
+	return rules, nil
+}
+
+func MatchRateRule(rules []*RateRule, action string) *RateRule {
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Pattern, action); ok {
+			return rule
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 客户端 IP, unix socket 连接返回 unix
+ */
+func ClientIP(rr *http.Request) string {
+	host, _, err := net.SplitHostPort(rr.RemoteAddr)
+	if err != nil {
+		host = rr.RemoteAddr
+	}
+	if host == "" || host == "@" {
+		return "unix"
+	}
+	return host
+}
+
+/**
+ * 限流和锁定使用的客户端标识, unix socket 连接使用对端 uid:<n>, 获取不到对端身份时使用 ip
+ */
+func ClientKey(rr *http.Request, ip string) string {
+	if cred, ok := rr.Context().Value(peerKey{}).(*PeerCred); ok && cred != nil {
+		return fmt.Sprintf("uid:%d", cred.Uid)
+	}
+	return ip
+}
+
+/**
+ * 客户端 IP 是否被锁定, 返回剩余时长
+ */
+func (this *RateLimiter) Locked(ip string, now time.Time) time.Duration {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if fail, ok := this.fails[ip]; ok && now.Before(fail.until) {
+		return fail.until.Sub(now)
+	}
+	return 0
+}
+
+/**
+ * 记录认证失败, 返回锁定时长, 0 表示未锁定
+ */
+func (this *RateLimiter) Fail(ip string, now time.Time) time.Duration {
+	if this.Fails <= 0 {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.fails == nil {
+		this.fails = map[string]*authFail{}
+	}
+	if len(this.fails) > 4096 {
+		for key, fail := range this.fails {
+			if now.Sub(fail.last) > this.MaxLock && now.After(fail.until) {
+				delete(this.fails, key)
+			}
+		}
+	}
+	fail, ok := this.fails[ip]
+	if !ok || now.Sub(fail.last) > this.MaxLock && now.After(fail.until) {
+		// 长时间没有失败, 重新计数
+		fail = &authFail{}
+		this.fails[ip] = fail
+	}
+	fail.count++
+	fail.last = now
+	if fail.count < this.Fails {
+		return 0
+	}
+	lock := this.MaxLock
+	if shift := fail.count - this.Fails; shift < 30 {
+		lock = min(this.Lockout<<shift, this.MaxLock)
+	}
+	fail.until = now.Add(lock)
+	return lock
+}
+
+/**
+ * 认证成功, 清除失败记录
+ */
+func (this *RateLimiter) Success(ip string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.fails, ip)
+}
+
+/**
+ * 检查限流, 返回需要等待的时长, 0 表示允许
+ * 同时检查客户端 IP 和认证主体, 任何一个超限都不消耗令牌
+ */
+func (this *RateLimiter) Allow(ip, principal, action string, now time.Time) time.Duration {
+	keys, rules := []string{}, []*RateRule{}
+	if rule := MatchRateRule(this.IpRules, action); rule != nil {
+		keys, rules = append(keys, "ip|"+ip+"|"+rule.Pattern), append(rules, rule)
+	}
+	if rule := MatchRateRule(this.TokenRules, action); rule != nil && principal != "" {
+		keys, rules = append(keys, "pp|"+principal+"|"+rule.Pattern), append(rules, rule)
+	}
+	if len(keys) == 0 {
+		return 0
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.buckets == nil {
+		this.buckets = map[string]*rateBucket{}
+	}
+	if len(this.buckets) > 4096 {
+		this.cleanup(now)
+	}
+	wait, buckets := time.Duration(0), []*rateBucket{}
+	for idx, key := range keys {
+		rule := rules[idx]
+		bucket, ok := this.buckets[key]
+		if !ok {
+			bucket = &rateBucket{tokens: float64(rule.Count), last: now, period: rule.Period}
+			this.buckets[key] = bucket
+		}
+		// 补充令牌
+		rate := float64(rule.Count) / float64(rule.Period)
+		bucket.tokens = math.Min(float64(rule.Count), bucket.tokens+float64(now.Sub(bucket.last))*rate)
+		bucket.last = now
+		if bucket.tokens < 1 {
+			wait = max(wait, time.Duration((1-bucket.tokens)/rate))
+		}
+		buckets = append(buckets, bucket)
+	}
+	if wait > 0 {
+		return wait
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return 0
+}
+
+/**
+ * 删除已补满的令牌桶
+ */
+func (this *RateLimiter) cleanup(now time.Time) {
+	for key, bucket := range this.buckets {
+		if now.Sub(bucket.last) >= bucket.period {
+			delete(this.buckets, key)
+		}
+	}
+}
+
+/**
+ * 超限响应, HTTP 429 和 Retry-After
+ */
+func TooManyRequests(rr *http.Request, ww http.ResponseWriter, wait time.Duration, resp *Result) {
+	if rw, ok := ww.(*ResultWriter); ok {
+		rw.Result = resp
+	}
+	secs := int(math.Ceil(wait.Seconds()))
+	ww.Header().Set("Content-Type", "application/json; charset=utf-8")
+	ww.Header().Set("Retry-After", strconv.Itoa(max(secs, 1)))
+	ww.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(ww).Encode(resp)
+}
+
+func retryMessage(prefix string, wait time.Duration) string {
+	return fmt.Sprintf("%s, %d 秒后重试", prefix, max(int(math.Ceil(wait.Seconds())), 1))
+}
@@ -0,0 +1,36 @@
+package app
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientKeyPeer(t *testing.T) {
+	peer := func(cred *PeerCred) string {
+		rr := httptest.NewRequest("POST", "/", nil)
+		rr.RemoteAddr = "@"
+		rr = rr.WithContext(context.WithValue(rr.Context(), peerKey{}, cred))
+		return ClientKey(rr, ClientIP(rr))
+	}
+	if key := peer(&PeerCred{Uid: 1000}); key != "uid:1000" {
+		t.Fatalf("key: %s", key)
+	}
+	if key := peer(nil); key != "unix" {
+		t.Fatalf("key without cred: %s", key)
+	}
+	// 不同 uid 的对端分别锁定
+	limit := &RateLimiter{Fails: 1, Lockout: time.Minute, MaxLock: time.Hour}
+	now := time.Now()
+	if limit.Fail(peer(&PeerCred{Uid: 1000}), now) == 0 {
+		t.Fatal("not locked")
+	}
+	if limit.Locked(peer(&PeerCred{Uid: 1001}), now) > 0 {
+		t.Fatal("other uid locked")
+	}
+	rr := httptest.NewRequest("POST", "/", nil)
+	if key := ClientKey(rr, ClientIP(rr)); key != "192.0.2.1" {
+		t.Fatalf("tcp key: %s", key)
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	json_reader "github.com/xtls/xray-core/infra/conf/json"
+)
+
+/**
+ * 敏感字段, 不区分大小写, 覆盖各协议 settings 和 streamSettings 中的凭据
+ * vless/vmess: id, trojan/shadowsocks: password, socks/http: pass
+ * wireguard: secretKey, preSharedKey, reality: privateKey, shortIds
+ * tls: certificate, key (内联证书和私钥), kcp: seed
+ * 其他字段中的 URL 使用 RedactURL 脱敏
+ */
+var SecretKeys = map[string]bool{
+	"id": true, "password": true, "pass": true, "privatekey": true, "secretkey": true,
+	"presharedkey": true, "psk": true, "key": true, "seed": true, "token": true,
+	"secret": true, "shortids": true, "certificate": true,
+}
+
+/**
+ * 需要 reveal-secrets 权限的操作, 响应本身就是凭据, 无法脱敏
+ */
+var SecretActions = []string{"GetShareLink", "GetQrCode", "GetClientConf", "GetClash", "GetSingBox", "GetSubscribe", "UpdSubscribe"}
+
+const SecretMask = "***"
+
+func IsSecretAction(action string) bool {
+	name := action[strings.LastIndexByte(action, '.')+1:]
+	for _, item := range SecretActions {
+		if name == item {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 替换 JSON 中的敏感字段
+ */
+func RedactSecrets(data any) any {
+	switch val := data.(type) {
+	case map[string]any:
+		for key, item := range val {
+			if SecretKeys[strings.ToLower(key)] {
+				val[key] = SecretMask
+			} else {
+				val[key] = RedactSecrets(item)
+			}
+		}
+	case []any:
+		for idx, item := range val {
+			val[idx] = RedactSecrets(item)
+		}
+	case string:
+		return RedactURL(val)
+	}
+	return data
+}
+
+/**
+ * 脱敏 URL 中可能包含凭据的部分: 用户信息, 路径, 查询参数, 如订阅地址和分享链接
+ * 不是 URL 的字符串原样返回
+ */
+func RedactURL(str string) string {
+	if !strings.Contains(str, "://") {
+		return str
+	}
+	uri, err := url.Parse(str)
+	if err != nil || uri.Host == "" {
+		return str
+	}
+	text := uri.Scheme + "://"
+	if uri.User != nil {
+		text += SecretMask + "@"
+	}
+	text += uri.Host
+	if uri.Path != "" && uri.Path != "/" {
+		text += "/" + SecretMask
+	} else {
+		text += uri.Path
+	}
+	if uri.RawQuery != "" {
+		keys := []string{}
+		for key := range uri.Query() {
+			keys = append(keys, url.QueryEscape(key)+"="+SecretMask)
+		}
+		sort.Strings(keys)
+		text += "?" + strings.Join(keys, "&")
+	}
+	if uri.Fragment != "" {
+		text += "#" + uri.EscapedFragment()
+	}
+	return text
+}
+
+/**
+ * 脱敏任意值, 先转为 JSON, 无法转换的值原样返回
+ */
+func RedactValue(data any) any {
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return data
+	}
+	var val any
+	if err := json.Unmarshal(bts, &val); err != nil {
+		return data
+	}
+	return RedactSecrets(val)
+}
+
+/**
+ * 脱敏 JSON 文本, 用于打印配置, 支持注释, 解析失败时返回空
+ */
+func RedactJSON(bts []byte) (string, bool) {
+	bts, err := io.ReadAll(&json_reader.Reader{Reader: bytes.NewReader(bts)})
+	if err != nil {
+		return "", false
+	}
+	var val any
+	if err := json.Unmarshal(bts, &val); err != nil {
+		return "", false
+	}
+	out, err := json.MarshalIndent(RedactSecrets(val), "", "  ")
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+/**
+ * 是否可以查看凭据, 未启用认证时允许
+ */
+func (this *Principal) Reveal() bool {
+	if this.Kind == "none" {
+		return true
+	}
+	for _, scope := range this.Scopes {
+		if scope == "admin" || scope == "reveal-secrets" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+/**
+ * 远程订阅源, 创建的出站 tag 为 prefix-名称
+ * prefix 不能包含 "-", 保证不同订阅源的出站 tag 不会重复
+ */
+type RemoteSource struct {
+	Prefix   string `json:"prefix"`
+	Url      string `json:"url"`
+	Interval int    `json:"interval"` // 刷新间隔(秒)
+
+	Outbounds map[string]string `json:"outbounds"` // 已创建出站 tag -> 配置摘要
+	Updated   string            `json:"updated,omitempty"`
+	Message   string            `json:"message,omitempty"` // 最近一次错误
+
+	updated time.Time
+	xray    *core.Instance // 创建出站的实例, Xray 重启后需重新创建
+}
+
+/**
+ * 远程订阅, 定期拉取订阅内容, 与已创建的出站比较后增删
+ */
+type RemoteStore struct {
+	File    string        // 存储文件
+	Timeout time.Duration // 拉取超时
+
+	lock    sync.Mutex
+	sources map[string]*RemoteSource
+	stop    chan struct{}
+}
+
+/**
+ * 复制订阅源, 返回给调用方, 避免在锁外读取正在刷新的订阅源
+ */
+func (this *RemoteSource) clone() *RemoteSource {
+	src := *this
+	src.Outbounds = make(map[string]string, len(this.Outbounds))
+	for tag, digest := range this.Outbounds {
+		src.Outbounds[tag] = digest
+	}
+	return &src
+}
+
+// ----------------------------------------------------------------------------
+
+func (this *RemoteStore) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.sources = map[string]*RemoteSource{}
+	if this.File == "" {
+		return nil
+	}
+	bts, err := os.ReadFile(this.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(bts, &this.sources); err != nil {
+		return err
+	}
+	for prefix := range this.sources {
+		if strings.Contains(prefix, "-") {
+			fmt.Printf("忽略远程订阅, prefix 不能包含 -: %s\n", prefix)
+			delete(this.sources, prefix)
+		}
+	}
+	return nil
+}
+
+func (this *RemoteStore) Save() error {
+	if this.File == "" {
+		return nil
+	}
+	this.lock.Lock()
+	bts, err := json.Marshal(this.sources)
+	this.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := this.File + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.File)
+}
+
+/**
+ * 启动定期刷新
+ */
+func (this *RemoteStore) Start(serve *XrayServe, events *Events) {
+	if err := this.Load(); err != nil {
+		fmt.Printf("加载远程订阅失败: %s\n", err.Error())
+	}
+	this.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-this.stop:
+				return
+			case now := <-ticker.C:
+				this.Check(serve, events, now)
+			}
+		}
+	}()
+}
+
+func (this *RemoteStore) Close() {
+	if this.stop == nil {
+		return
+	}
+	close(this.stop)
+	this.stop = nil
+}
+
+/**
+ * 刷新到期的订阅源
+ */
+func (this *RemoteStore) Check(serve *XrayServe, events *Events, now time.Time) {
+	if !serve.IsRunning() {
+		return
+	}
+	prefixes, xray := []string{}, serve.Instance()
+	this.lock.Lock()
+	for prefix, src := range this.sources {
+		elapsed := now.Sub(src.updated)
+		if src.xray != xray && elapsed >= 30*time.Second || elapsed >= time.Duration(src.Interval)*time.Second {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	this.lock.Unlock()
+	for _, prefix := range prefixes {
+		this.Refresh(serve, events, prefix)
+	}
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 添加或更新订阅源, 并立即刷新
+ */
+func (this *RemoteStore) Add(serve *XrayServe, events *Events, prefix, url string, interval int) (*RemoteSource, error) {
+	if prefix == "" || strings.Contains(prefix, "-") {
+		return nil, errors.New("无效的 prefix, 不能为空或包含 -")
+	}
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, errors.New("无效的 url")
+	}
+	this.lock.Lock()
+	if this.sources == nil {
+		this.sources = map[string]*RemoteSource{}
+	}
+	src := this.sources[prefix]
+	if src == nil {
+		src = &RemoteSource{Prefix: prefix, Outbounds: map[string]string{}}
+		this.sources[prefix] = src
+	}
+	src.Url, src.Interval = url, max(interval, 60)
+	this.lock.Unlock()
+	err := this.Refresh(serve, events, prefix)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return src.clone(), err
+}
+
+/**
+ * 删除订阅源及其创建的出站
+ */
+func (this *RemoteStore) Del(serve *XrayServe, prefix string) error {
+	this.lock.Lock()
+	src := this.sources[prefix]
+	delete(this.sources, prefix)
+	this.lock.Unlock()
+	if src == nil {
+		return errors.New("订阅源未找到: " + prefix)
+	}
+	if src.xray == serve.Instance() {
+		for tag := range src.Outbounds {
+			serve.DelOutbound(tag, false)
+		}
+	}
+	return this.Save()
+}
+
+func (this *RemoteStore) List() []*RemoteSource {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	data := []*RemoteSource{}
+	for _, src := range this.sources {
+		data = append(data, src.clone())
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Prefix < data[j].Prefix })
+	return data
+}
+
+/**
+ * 拉取订阅源, 增删出站
+ */
+func (this *RemoteStore) Refresh(serve *XrayServe, events *Events, prefix string) error {
+	this.lock.Lock()
+	src := this.sources[prefix]
+	addr := ""
+	if src != nil {
+		addr = src.Url
+	}
+	this.lock.Unlock()
+	if src == nil {
+		return errors.New("订阅源未找到: " + prefix)
+	}
+	cotbs, err := this.fetch(prefix, addr)
+	now := time.Now()
+	this.lock.Lock()
+	defer func() {
+		this.lock.Unlock()
+		if err := this.Save(); err != nil {
+			fmt.Printf("保存远程订阅失败: %s\n", err.Error())
+		}
+	}()
+	src.updated, src.Updated = now, now.Format(time.RFC3339)
+	if err != nil {
+		src.Message = err.Error()
+		events.Emit("remote.error", fmt.Sprintf("拉取订阅失败: %s, %s", prefix, err.Error()), addr)
+		return err
+	}
+	if xray := serve.Instance(); src.xray != xray {
+		src.xray, src.Outbounds = xray, map[string]string{} // Xray 已重启, 原出站不存在
+	}
+	added, removed, errs := src.apply(serve, cotbs)
+	src.Message = strings.Join(errs, "; ")
+	if len(added) > 0 || len(removed) > 0 {
+		events.Emit("remote.update", fmt.Sprintf("订阅更新: %s, +%d -%d", prefix, len(added), len(removed)), map[string]any{"added": added, "removed": removed})
+	}
+	if len(errs) > 0 {
+		return errors.New(src.Message)
+	}
+	return nil
+}
+
+/**
+ * 比较并应用出站, 配置变化的出站先删除再添加
+ * 在刷新协程中运行, 出站增删通过 XrayServe 加锁, 与 API 的修改串行
+ */
+func (this *RemoteSource) apply(serve *XrayServe, cotbs []conf.OutboundDetourConfig) ([]string, []string, []string) {
+	added, removed, errs := []string{}, []string{}, []string{}
+	digests := map[string]string{}
+	for _, cotb := range cotbs {
+		bts, _ := json.Marshal(cotb)
+		sum := sha256.Sum256(bts)
+		digests[cotb.Tag] = hex.EncodeToString(sum[:])
+	}
+	for tag, digest := range this.Outbounds {
+		if digests[tag] == digest {
+			continue
+		}
+		if err := serve.DelOutbound(tag, false); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", tag, err.Error()))
+		}
+		delete(this.Outbounds, tag)
+		removed = append(removed, tag)
+	}
+	for _, cotb := range cotbs {
+		if _, ok := this.Outbounds[cotb.Tag]; ok {
+			continue
+		}
+		if err := serve.AddOutbound(cotb, false); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", cotb.Tag, err.Error()))
+			continue
+		}
+		this.Outbounds[cotb.Tag] = digests[cotb.Tag]
+		added = append(added, cotb.Tag)
+	}
+	return added, removed, errs
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 拉取并解析订阅内容
+ */
+func (this *RemoteStore) fetch(prefix, addr string) ([]conf.OutboundDetourConfig, error) {
+	timeout := this.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(addr)
+	if uerr, ok := err.(*url.Error); ok {
+		return nil, uerr.Err // 错误信息中不包含订阅地址
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("订阅响应: " + resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ParseRemote(prefix, body)
+}
+
+/**
+ * 解析订阅内容, 支持 Xray JSON 配置 或 base64/明文 链接列表
+ * 出站 tag 为 prefix-名称, 名称重复时追加序号
+ */
+func ParseRemote(prefix string, body []byte) ([]conf.OutboundDetourConfig, error) {
+	cotbs := []conf.OutboundDetourConfig{}
+	tags := map[string]int{}
+	tagged := func(name string) string {
+		tag := prefix + "-" + name
+		if tags[tag]++; tags[tag] > 1 {
+			tag = fmt.Sprintf("%s-%d", tag, tags[tag])
+		}
+		return tag
+	}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		xcc := struct {
+			Outbounds []conf.OutboundDetourConfig `json:"outbounds"`
+		}{}
+		if err := json.Unmarshal(body, &xcc); err != nil {
+			return nil, errors.New("无效的 JSON: " + err.Error())
+		}
+		for idx, cotb := range xcc.Outbounds {
+			switch strings.ToLower(cotb.Protocol) {
+			case "freedom", "blackhole", "dns", "loopback":
+				continue // 只导入代理出站
+			}
+			if cotb.Tag == "" {
+				cotb.Tag = fmt.Sprint(idx + 1)
+			}
+			cotb.Tag = tagged(cotb.Tag)
+			cotbs = append(cotbs, cotb)
+		}
+		return cotbs, nil
+	}
+	if bts, err := DecodeBase64(strings.Join(strings.Fields(string(body)), "")); err == nil {
+		body = bts
+	}
+	errs := []string{}
+	for idx, link := range SplitShareLinks(body) {
+		info, err := ParseShareLink(link)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("#%d: %s", idx+1, err.Error()))
+			continue
+		}
+		name := info.Remark
+		if name == "" {
+			name = fmt.Sprint(idx + 1)
+		}
+		cotb, err := info.Outbound(tagged(name))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, err.Error()))
+			continue
+		}
+		cotbs = append(cotbs, cotb)
+	}
+	if len(cotbs) == 0 && len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	for _, msg := range errs {
+		fmt.Printf("订阅跳过链接: %s, %s\n", prefix, msg)
+	}
+	return cotbs, nil
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testUuid = "a3482e88-686a-4a58-8126-99c9df64b7bf"
+
+func testXray(t *testing.T) *XrayServe {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "xray.json")
+	if err := os.WriteFile(file, []byte(`{"policy": {}, "routing": {"rules": []}, "outbounds": [{"protocol": "freedom", "tag": "direct"}]}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	serve := &XrayServe{Xrayc: file}
+	if msg := serve.StartXray(); msg != "" {
+		t.Fatal(msg)
+	}
+	t.Cleanup(func() { serve.XrayA.Close() })
+	return serve
+}
+
+/**
+ * 订阅服务, 内容可在测试中修改
+ */
+type testRemote struct {
+	lock sync.Mutex
+	body string
+}
+
+func (this *testRemote) set(body string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.body = body
+}
+
+func (this *testRemote) start(t *testing.T) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(ww http.ResponseWriter, rr *http.Request) {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		ww.Write([]byte(this.body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func vlessLink(port, name string) string {
+	return "vless://" + testUuid + "@1.2.3.4:" + port + "?type=tcp#" + name
+}
+
+func outboundTags(t *testing.T, serve *XrayServe) []string {
+	t.Helper()
+	list, err := serve.LstOutbound0()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []string{}
+	for _, tag := range list {
+		tags = append(tags, tag.(string))
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func sourceTags(src *RemoteSource) []string {
+	tags := []string{}
+	for tag := range src.Outbounds {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func TestRemoteBase64(t *testing.T) {
+	serve := testXray(t)
+	remote := &testRemote{}
+	remote.set(base64.StdEncoding.EncodeToString([]byte(vlessLink("443", "a") + "\n" + vlessLink("444", "b") + "\nbad://link\n")))
+	store := &RemoteStore{}
+	src, err := store.Add(serve, &Events{}, "r", remote.start(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sourceTags(src), ","); got != "r-a,r-b" {
+		t.Fatalf("source outbounds: %s", got)
+	}
+	if got := strings.Join(outboundTags(t, serve), ","); got != "direct,r-a,r-b" {
+		t.Fatalf("xray outbounds: %s", got)
+	}
+	if src.Interval != 60 {
+		t.Fatalf("interval: %d", src.Interval)
+	}
+}
+
+func TestRemotePrefix(t *testing.T) {
+	serve := testXray(t)
+	remote := &testRemote{}
+	remote.set(vlessLink("443", "b-c"))
+	store := &RemoteStore{}
+	if _, err := store.Add(serve, &Events{}, "a", remote.start(t), 0); err != nil {
+		t.Fatal(err)
+	}
+	// a-b + c 与 a + b-c 的出站 tag 相同
+	if _, err := store.Add(serve, &Events{}, "a-b", remote.start(t), 0); err == nil {
+		t.Fatal("prefix with - accepted")
+	}
+	if got := strings.Join(outboundTags(t, serve), ","); got != "a-b-c,direct" {
+		t.Fatalf("xray outbounds: %s", got)
+	}
+}
+
+func TestRemoteJSON(t *testing.T) {
+	serve := testXray(t)
+	remote := &testRemote{}
+	remote.set(`{"outbounds": [
+		{"tag": "x", "protocol": "vless", "settings": {"vnext": [{"address": "1.2.3.4", "port": 443, "users": [{"id": "` + testUuid + `", "encryption": "none"}]}]}},
+		{"protocol": "trojan", "settings": {"servers": [{"address": "1.2.3.4", "port": 443, "password": "p"}]}},
+		{"tag": "direct", "protocol": "freedom"}
+	]}`)
+	store := &RemoteStore{}
+	src, err := store.Add(serve, &Events{}, "j", remote.start(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(sourceTags(src), ","); got != "j-2,j-x" {
+		t.Fatalf("source outbounds: %s", got)
+	}
+	if got := strings.Join(outboundTags(t, serve), ","); got != "direct,j-2,j-x" {
+		t.Fatalf("xray outbounds: %s", got)
+	}
+}
+
+func TestRemoteDiff(t *testing.T) {
+	serve := testXray(t)
+	remote := &testRemote{}
+	remote.set(vlessLink("443", "a") + "\n" + vlessLink("444", "b") + "\n" + vlessLink("445", "c"))
+	store := &RemoteStore{}
+	events := &Events{}
+	src, err := store.Add(serve, events, "r", remote.start(t), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests := src.Outbounds
+
+	// a 删除, b 修改, c 不变, d 新增
+	remote.set(vlessLink("454", "b") + "\n" + vlessLink("445", "c") + "\n" + vlessLink("446", "d"))
+	if err := store.Refresh(serve, events, "r"); err != nil {
+		t.Fatal(err)
+	}
+	list := store.List()
+	if len(list) != 1 {
+		t.Fatalf("sources: %d", len(list))
+	}
+	if got := strings.Join(sourceTags(list[0]), ","); got != "r-b,r-c,r-d" {
+		t.Fatalf("source outbounds: %s", got)
+	}
+	if list[0].Outbounds["r-b"] == digests["r-b"] || list[0].Outbounds["r-c"] != digests["r-c"] {
+		t.Fatalf("digests not updated: %v -> %v", digests, list[0].Outbounds)
+	}
+	if got := strings.Join(outboundTags(t, serve), ","); got != "direct,r-b,r-c,r-d" {
+		t.Fatalf("xray outbounds: %s", got)
+	}
+	evts := events.List("remote.update")
+	if len(evts) != 2 {
+		t.Fatalf("events: %d", len(evts))
+	}
+	data := evts[len(evts)-1].Data.(map[string]any)
+	added, removed := data["added"].([]string), data["removed"].([]string)
+	sort.Strings(added)
+	sort.Strings(removed)
+	if strings.Join(added, ",") != "r-b,r-d" || strings.Join(removed, ",") != "r-a,r-b" {
+		t.Fatalf("added %v, removed %v", added, removed)
+	}
+
+	// 返回的是副本, 修改不影响订阅源
+	list[0].Outbounds["r-x"] = "x"
+	if _, ok := store.List()[0].Outbounds["r-x"]; ok {
+		t.Fatal("List returned live source")
+	}
+
+	if err := store.Del(serve, "r"); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(outboundTags(t, serve), ","); got != "direct" {
+		t.Fatalf("xray outbounds after delete: %s", got)
+	}
+}
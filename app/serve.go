@@ -33,16 +33,74 @@ func Serve() {
 		offset int
 		config string
 		ver    bool
+		tsecs  int
+		lsecs  int
+		cdays  int
+		hsecs  int
+		cscope string
+		uscope string
+		uids   string
+		gids   string
+		iprate string
+		tkrate string
+		fsecs  int
+		asize  int
+		ckey   string
+		okey   string
+		rotate bool
+		dfile  string
 	)
 	handler := NewHandler()
 	// ------------------------------------------------------------------------
 	flag.StringVar(&addr, "addr", "127.0.0.1", "HTTP服务地址")
-	flag.IntVar(&port, "port", 8191, "HTTP服务端口")
+	flag.IntVar(&port, "port", 8191, "HTTP服务端口, 0 不监听 TCP")
+	flag.StringVar(&handler.Unix.Path, "unix", "", "HTTP服务 unix socket 文件")
+	flag.StringVar(&handler.Unix.Mode, "unix-mode", "0660", "unix socket 文件权限")
+	flag.StringVar(&handler.Unix.Owner, "unix-owner", "", "unix socket 文件所有者, user[:group]")
+	flag.StringVar(&uids, "unix-uids", "", "允许免令牌访问的对端 UID, 逗号分隔")
+	flag.StringVar(&gids, "unix-gids", "", "允许免令牌访问的对端 GID, 逗号分隔")
+	flag.StringVar(&uscope, "unix-scopes", "admin", "对端的权限, 逗号分隔")
 	flag.StringVar(&handler.Token, "token", "", "访问令牌，不配置跳过验证")
+	flag.IntVar(&hsecs, "hmac-window", 300, "HMAC 签名时间戳允许的偏差(秒)")
+	flag.StringVar(&handler.Jwt.KeyFile, "jwt-key", "", "JWT 验证公钥(PEM)文件")
+	flag.StringVar(&handler.Jwt.JwksFile, "jwt-jwks", "", "JWT 验证 JWKS 文件")
+	flag.StringVar(&handler.Jwt.Issuer, "jwt-iss", "", "JWT 签发者, 不配置不校验")
+	flag.StringVar(&handler.Jwt.Audience, "jwt-aud", "", "JWT 受众, 不配置不校验")
+	flag.StringVar(&handler.Tls.CertFile, "tls-cert", "", "HTTP服务 TLS 证书文件")
+	flag.StringVar(&handler.Tls.KeyFile, "tls-key", "", "HTTP服务 TLS 私钥文件")
+	flag.StringVar(&handler.Tls.ClientCA, "tls-client-ca", "", "客户端 CA 文件, 启用 mTLS")
+	flag.BoolVar(&handler.Tls.Required, "tls-client-required", false, "是否要求客户端证书")
+	flag.StringVar(&cscope, "tls-client-scopes", "admin", "客户端证书的权限, 逗号分隔")
+	flag.StringVar(&handler.Acl.File, "acl-file", "", "访问控制配置文件, 修改后自动重新加载")
+	flag.StringVar(&iprate, "rate-ip", "", "按客户端IP限流, 格式: pattern=count/duration, 逗号分隔")
+	flag.StringVar(&tkrate, "rate-token", "", "按认证主体限流, 格式同 -rate-ip")
+	flag.IntVar(&handler.Limit.Fails, "auth-fails", 5, "连续认证失败锁定次数, 0 不锁定")
+	flag.IntVar(&fsecs, "auth-lockout", 30, "首次锁定时长(秒), 之后每次失败加倍, 最长 1 小时")
+	flag.StringVar(&handler.Tokens.File, "token-file", "", "令牌库文件, 不配置不启用, 需要配置文件密钥")
 	flag.StringVar(&config, "c", "xray.json", "配置文件, 默认(xray.json)")
 	flag.IntVar(&offset, "offset", 0, "配置文件偏移量")
+	flag.StringVar(&ckey, "conf-key", "", "配置文件加密密钥文件, 默认使用环境变量 XRAYW_CONF_KEY")
+	flag.StringVar(&okey, "conf-old-key", "", "配置文件旧密钥文件, 只用于解密, 默认使用环境变量 XRAYW_CONF_OLD_KEY")
+	flag.BoolVar(&rotate, "rotate-key", false, "使用新密钥重新加密运行配置文件(配置文件.偏移量)后退出, 不修改原始配置文件")
+	flag.StringVar(&dfile, "decrypt", "", "解密文件并输出到标准输出后退出, 用于查看或导出加密的配置文件")
 	flag.BoolVar(&handler.Serve.Reset, "reset", false, "是否重置配置文件")
 	flag.BoolVar(&handler.Serve.Print, "print", false, "是否打印配置文件")
+	flag.BoolVar(&handler.Serve.Plain, "print-secrets", false, "打印配置文件时不脱敏")
+	flag.IntVar(&tsecs, "traffic", 60, "流量历史采样间隔(秒), 0 不采样")
+	flag.StringVar(&handler.Traffic.File, "traffic-file", "", "流量历史文件, 默认(配置文件.traffic)")
+	flag.IntVar(&handler.IpLimit.Limit, "iplimit", 0, "每个用户最大IP数量, 0 不限制")
+	flag.IntVar(&lsecs, "iplimit-cool", 300, "超出IP限制的封禁时长(秒)")
+	flag.StringVar(&handler.IpLimit.Outbound, "iplimit-out", "blocked", "超出IP限制的封禁出站")
+	flag.StringVar(&handler.Subscribe.Key, "sub-key", "", "订阅密钥, 不配置不提供订阅")
+	flag.StringVar(&handler.Subscribe.Host, "sub-host", "", "订阅中服务的公网地址, 默认使用请求的 Host")
+	flag.StringVar(&handler.Subscribe.Path, "sub-path", "/sub/", "订阅路径前缀")
+	flag.StringVar(&handler.Subscribe.File, "sub-file", "", "订阅 nonce 文件, 默认(配置文件.subs)")
+	flag.StringVar(&handler.Remote.File, "remote-file", "", "远程订阅文件, 默认(配置文件.remote)")
+	flag.StringVar(&handler.Audit.File, "audit-file", "", "审计日志文件, 不配置不记录")
+	flag.IntVar(&asize, "audit-size", 10, "审计日志轮转大小(MB)")
+	flag.IntVar(&handler.Audit.Keep, "audit-keep", 5, "审计日志保留的轮转文件数量")
+	flag.StringVar(&handler.Certs.Dir, "cert-dir", "", "证书目录, 默认(配置文件.certs)")
+	flag.IntVar(&cdays, "cert-warn", 14, "证书到期提醒天数, 0 不提醒")
 	flag.BoolVar(&ver, "version", false, "打印版本信息")
 	flag.Parse()
 
@@ -52,24 +110,147 @@ func Serve() {
 		return
 	}
 	// ------------------------------------------------------------------------
+	if key, err := LoadConfKey(ckey, "XRAYW_CONF_KEY"); err != nil {
+		log.Fatalf("加载配置文件密钥失败: %s\n", err)
+	} else if old, err := LoadConfKey(okey, "XRAYW_CONF_OLD_KEY"); err != nil {
+		log.Fatalf("加载配置文件旧密钥失败: %s\n", err)
+	} else if key != nil {
+		handler.Serve.Crypt = NewConfCipher(key, old)
+	}
+	if dfile != "" {
+		bts, err := os.ReadFile(dfile)
+		if err == nil {
+			bts, err = handler.Serve.Crypt.Open(bts)
+		}
+		if err != nil {
+			log.Fatalf("解密文件失败: %s\n", err)
+		}
+		os.Stdout.Write(bts)
+		return
+	}
+	if rotate {
+		if handler.Serve.Crypt == nil {
+			log.Fatalf("轮换密钥需要新密钥(-conf-key 或环境变量 XRAYW_CONF_KEY)\n")
+		}
+		// 原始配置文件由用户编辑, 只轮换程序保存的运行配置文件
+		if err := handler.Serve.Crypt.Rotate(fmt.Sprintf("%s.%d", config, offset)); err != nil {
+			log.Fatalf("轮换密钥失败: %s\n", err)
+		}
+		return
+	}
+	// ------------------------------------------------------------------------
+	handler.Nonces.Window = time.Duration(hsecs) * time.Second
+	var err error
+	if handler.Tls.Scopes, err = ParseScopes(cscope); err != nil {
+		log.Fatalf("无效的客户端证书权限: %s\n", err)
+	}
+	if handler.Unix.Scopes, err = ParseScopes(uscope); err != nil {
+		log.Fatalf("无效的对端权限: %s\n", err)
+	}
+	if handler.Unix.Uids, err = ParseIds(uids); err != nil {
+		log.Fatalf("无效的对端 UID: %s\n", err)
+	}
+	if handler.Unix.Gids, err = ParseIds(gids); err != nil {
+		log.Fatalf("无效的对端 GID: %s\n", err)
+	}
+	if handler.Limit.IpRules, err = ParseRateRules(iprate); err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	if handler.Limit.TokenRules, err = ParseRateRules(tkrate); err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	if err := handler.Jwt.Check(); err != nil {
+		log.Fatalf("%s\n", err)
+	}
+	handler.Limit.Lockout = time.Duration(max(fsecs, 1)) * time.Second
+	handler.Limit.MaxLock = max(time.Hour, handler.Limit.Lockout)
+	if port == 0 && !handler.Unix.Enabled() {
+		log.Fatalf("未配置监听地址\n")
+	}
+	if handler.Acl.Enabled() {
+		if err := handler.Acl.Load(); err != nil {
+			log.Fatalf("加载访问控制配置失败: %s\n", err)
+		}
+	}
+	handler.Tokens.Crypt = handler.Serve.Crypt
+	if err := handler.Tokens.Load(); err != nil {
+		log.Fatalf("加载令牌库失败: %s\n", err)
+	}
+	if handler.Subscribe.File == "" {
+		handler.Subscribe.File = config + ".subs"
+	}
+	if err := handler.Subscribe.Load(); err != nil {
+		log.Fatalf("加载订阅 nonce 失败: %s\n", err)
+	}
+	handler.Audit.MaxSize = int64(asize) * 1024 * 1024
+	if handler.Certs.Dir == "" {
+		handler.Certs.Dir = config + ".certs"
+	}
 	handler.Serve.Xrayc = fmt.Sprintf("%s.%d", config, offset) // 配置文件，尽量不动原始配置
 	fmt.Printf("正在启动Xray,配置文件: %s -> %s\n", config, handler.Serve.Xrayc)
 	go handler.Serve.StartXray() // 启动Xray
 	// ------------------------------------------------------------------------
-	fmt.Printf("HTTP服务启动,监听地址: %s:%d\n", addr, port)
+	if handler.Traffic.File == "" {
+		handler.Traffic.File = config + ".traffic"
+	}
+	handler.Traffic.Interval = time.Duration(tsecs) * time.Second
+	handler.Traffic.Start(&handler.Serve) // 流量历史采样
+	handler.IpLimit.Cooldown = time.Duration(lsecs) * time.Second
+	handler.IpLimit.Start(&handler.Serve, &handler.Events) // 用户IP限制
+	if handler.Remote.File == "" {
+		handler.Remote.File = config + ".remote"
+	}
+	handler.Remote.Start(&handler.Serve, &handler.Events) // 远程订阅
+	handler.Certs.Warn = time.Duration(cdays) * 24 * time.Hour
+	handler.Certs.Start(&handler.Serve, &handler.Events) // 证书到期提醒
+	// ------------------------------------------------------------------------
 	// http.ListenAndServe(fmt.Sprintf("%s:%d", addr, port), handler) // 启动HTTP服务
 	// ------------------------------------------------------------------------
 	// 启动HTTP服务， 并可优雅的终止
-	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", addr, port), Handler: handler}
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("listen: %s\n", err)
+	srv := &http.Server{Addr: fmt.Sprintf("%s:%d", addr, port), Handler: handler, ConnContext: handler.Unix.ConnContext}
+	if handler.Tls.Enabled() {
+		cfg, err := handler.Tls.Config()
+		if err != nil {
+			log.Fatalf("加载 TLS 证书失败: %s\n", err)
 		}
-	}()
+		srv.TLSConfig = cfg
+	}
+	if port > 0 {
+		fmt.Printf("HTTP服务启动,监听地址: %s:%d\n", addr, port)
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("listen: %s\n", err)
+			}
+		}()
+	}
+	if handler.Unix.Enabled() {
+		ln, err := handler.Unix.Listen()
+		if err != nil {
+			log.Fatalf("listen unix: %s\n", err)
+		}
+		fmt.Printf("HTTP服务启动,监听 socket: %s\n", handler.Unix.Path)
+		go func() {
+			// 本机通信, 不使用 TLS
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("listen unix: %s\n", err)
+			}
+		}()
+	}
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-sc
 	log.Println("shutdown server ...")
+	handler.Traffic.Close()
+	handler.IpLimit.Close()
+	handler.Remote.Close()
+	handler.Certs.Close()
+	defer handler.Audit.Close()
 	// 等待中断信号以优雅地关闭服务器（设置 5 秒的超时时间）
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -0,0 +1,370 @@
+package app
+
+import (
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+/**
+ * 分享信息, 由 inbound 配置推导出的客户端连接参数
+ */
+type ShareInfo struct {
+	Remark   string `json:"remark"`
+	Protocol string `json:"protocol"` // vless, vmess, trojan, shadowsocks
+	Address  string `json:"address"`
+	Port     uint32 `json:"port"`
+	Email    string `json:"email,omitempty"`
+	Id       string `json:"id,omitempty"`       // vless, vmess
+	User     string `json:"user,omitempty"`     // socks, http
+	Password string `json:"password,omitempty"` // trojan, shadowsocks
+	Method   string `json:"method,omitempty"`   // shadowsocks
+	Flow     string `json:"flow,omitempty"`     // vless
+
+	Network     string   `json:"network"`              // tcp, ws, grpc, xhttp, httpupgrade, kcp
+	HeaderType  string   `json:"headerType,omitempty"` // tcp, kcp
+	Path        string   `json:"path,omitempty"`
+	Host        string   `json:"host,omitempty"`
+	ServiceName string   `json:"serviceName,omitempty"` // grpc
+	Mode        string   `json:"mode,omitempty"`        // xhttp, grpc
+	Seed        string   `json:"seed,omitempty"`        // kcp
+	Security    string   `json:"security"`              // none, tls, reality
+	Sni         string   `json:"sni,omitempty"`
+	Fingerprint string   `json:"fp,omitempty"`
+	Alpn        []string `json:"alpn,omitempty"`
+	Insecure    bool     `json:"insecure,omitempty"`
+	PublicKey   string   `json:"pbk,omitempty"` // reality
+	ShortId     string   `json:"sid,omitempty"` // reality
+	SpiderX     string   `json:"spx,omitempty"` // reality
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 获取 inbound 中用户的分享信息, host 为服务的公网地址
+ */
+func (this *XrayServe) ShareInfo(tag, email, host string) (*ShareInfo, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.Xconf == nil {
+		return nil, errors.New("未初始化配置文件")
+	}
+	idx := this.FindInboundTag(tag)
+	if idx < 0 {
+		return nil, errors.New("inbound 未找到: " + tag)
+	}
+	return NewShareInfo(&this.Xconf.InboundConfigs[idx], email, host)
+}
+
+/**
+ * 根据 inbound 配置生成分享信息
+ */
+func NewShareInfo(cinb *conf.InboundDetourConfig, email, host string) (*ShareInfo, error) {
+	info := &ShareInfo{Remark: cinb.Tag, Protocol: cinb.Protocol, Address: host, Email: email}
+	if email != "" {
+		info.Remark = cinb.Tag + "-" + email
+	}
+	if cinb.PortList == nil || len(cinb.PortList.Range) == 0 {
+		return nil, errors.New("inbound 未指定端口: " + cinb.Tag)
+	}
+	info.Port = cinb.PortList.Range[0].From
+	// 用户
+	sets := map[string]any{}
+	if cinb.Settings != nil {
+		if err := json.Unmarshal(*cinb.Settings, &sets); err != nil {
+			return nil, err
+		}
+	}
+	clients, err := InboundClients(cinb)
+	if err != nil {
+		return nil, err
+	}
+	client := map[string]any(nil)
+	for _, cln := range clients {
+		if cln["email"] == email {
+			client = cln
+			break
+		}
+	}
+	switch cinb.Protocol {
+	case "vless":
+		if dec, _ := sets["decryption"].(string); dec != "" && dec != "none" {
+			return nil, errors.New("不支持 VLESS encryption: " + cinb.Tag)
+		}
+		if client == nil {
+			return nil, errors.New("用户未找到: " + email)
+		}
+		info.Id, _ = client["id"].(string)
+		info.Flow, _ = sets["flow"].(string)
+		if flow, ok := client["flow"].(string); ok {
+			info.Flow = flow
+		}
+	case "vmess":
+		if client == nil {
+			return nil, errors.New("用户未找到: " + email)
+		}
+		info.Id, _ = client["id"].(string)
+	case "trojan":
+		if client == nil {
+			return nil, errors.New("用户未找到: " + email)
+		}
+		info.Password, _ = client["password"].(string)
+	case "shadowsocks":
+		info.Method, _ = sets["method"].(string)
+		info.Password, _ = sets["password"].(string)
+		if client != nil {
+			if method, _ := client["method"].(string); method != "" {
+				info.Method = method
+			}
+			upwd, _ := client["password"].(string)
+			if strings.HasPrefix(info.Method, "2022-") && info.Password != "" {
+				info.Password = info.Password + ":" + upwd // 2022 多用户: 服务端密钥:用户密钥
+			} else {
+				info.Password = upwd
+			}
+		} else if email != "" || info.Password == "" {
+			return nil, errors.New("用户未找到: " + email)
+		}
+	default:
+		return nil, errors.New("不支持分享的协议: " + cinb.Protocol)
+	}
+	// 传输
+	info.Network, info.Security = "tcp", "none"
+	if err := info.stream(cinb.StreamSetting, host); err != nil {
+		return nil, err
+	}
+	// 客户端默认使用 TLS, 不生成明文 trojan 链接
+	if info.Protocol == "trojan" && info.Security == "none" {
+		return nil, errors.New("trojan inbound 未启用 TLS 或 REALITY: " + cinb.Tag)
+	}
+	return info, nil
+}
+
+func (info *ShareInfo) stream(ss *conf.StreamConfig, host string) error {
+	if ss == nil {
+		return nil
+	}
+	if ss.Network != nil {
+		info.Network = strings.ToLower(string(*ss.Network))
+	}
+	switch info.Network {
+	case "raw", "tcp":
+		info.Network = "tcp"
+		tcp := ss.TCPSettings
+		if ss.RAWSettings != nil {
+			tcp = ss.RAWSettings
+		}
+		if tcp != nil && len(tcp.HeaderConfig) > 0 {
+			hdr := struct {
+				Type    string `json:"type"`
+				Request struct {
+					Path    []string            `json:"path"`
+					Headers map[string][]string `json:"headers"`
+				} `json:"request"`
+			}{}
+			json.Unmarshal(tcp.HeaderConfig, &hdr)
+			if hdr.Type == "http" {
+				info.HeaderType = "http"
+				if len(hdr.Request.Path) > 0 {
+					info.Path = hdr.Request.Path[0]
+				}
+				if hosts := hdr.Request.Headers["Host"]; len(hosts) > 0 {
+					info.Host = hosts[0]
+				}
+			}
+		}
+	case "ws", "websocket":
+		info.Network = "ws"
+		if ws := ss.WSSettings; ws != nil {
+			info.Path, info.Host = ws.Path, ws.Host
+		}
+	case "httpupgrade":
+		if hu := ss.HTTPUPGRADESettings; hu != nil {
+			info.Path, info.Host = hu.Path, hu.Host
+		}
+	case "xhttp", "splithttp":
+		info.Network = "xhttp"
+		xh := ss.XHTTPSettings
+		if ss.SplitHTTPSettings != nil {
+			xh = ss.SplitHTTPSettings
+		}
+		if xh != nil {
+			info.Path, info.Host, info.Mode = xh.Path, xh.Host, xh.Mode
+		}
+	case "grpc":
+		if gr := ss.GRPCSettings; gr != nil {
+			info.ServiceName, info.Host = gr.ServiceName, gr.Authority
+			if gr.MultiMode {
+				info.Mode = "multi"
+			}
+		}
+	case "kcp", "mkcp":
+		info.Network = "kcp"
+		if kcp := ss.KCPSettings; kcp != nil {
+			if kcp.Seed != nil {
+				info.Seed = *kcp.Seed
+			}
+			if len(kcp.HeaderConfig) > 0 {
+				hdr := struct {
+					Type string `json:"type"`
+				}{}
+				json.Unmarshal(kcp.HeaderConfig, &hdr)
+				info.HeaderType = hdr.Type
+			}
+		}
+	default:
+		return errors.New("不支持分享的传输: " + info.Network)
+	}
+	switch strings.ToLower(ss.Security) {
+	case "", "none":
+	case "tls":
+		info.Security, info.Sni = "tls", host
+		if tls := ss.TLSSettings; tls != nil {
+			if tls.ServerName != "" {
+				info.Sni = tls.ServerName
+			}
+			info.Fingerprint = tls.Fingerprint
+			if tls.ALPN != nil {
+				info.Alpn = []string(*tls.ALPN)
+			}
+		}
+		if net.ParseIP(info.Sni) != nil {
+			info.Sni = "" // IP 不作为 SNI
+		}
+	case "reality":
+		rty := ss.REALITYSettings
+		if rty == nil {
+			return errors.New("未配置 realitySettings")
+		}
+		info.Security = "reality"
+		if len(rty.ServerNames) > 0 {
+			info.Sni = rty.ServerNames[0]
+		}
+		if len(rty.ShortIds) > 0 {
+			info.ShortId = rty.ShortIds[0]
+		}
+		info.Fingerprint, info.SpiderX = rty.Fingerprint, rty.SpiderX
+		if info.Fingerprint == "" {
+			info.Fingerprint = "chrome"
+		}
+		pbk, err := X25519PublicKey(rty.PrivateKey)
+		if err != nil {
+			return err
+		}
+		info.PublicKey = pbk
+	default:
+		return errors.New("不支持分享的安全类型: " + ss.Security)
+	}
+	return nil
+}
+
+/**
+ * 由 x25519 私钥计算公钥, 同 xray x25519 命令, 使用 base64 RawURL 编码
+ */
+func X25519PublicKey(key string) (string, error) {
+	bts, err := base64.RawURLEncoding.DecodeString(key)
+	if err != nil || len(bts) != 32 {
+		return "", errors.New("无效的 x25519 私钥")
+	}
+	pri, err := ecdh.X25519().NewPrivateKey(bts)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pri.PublicKey().Bytes()), nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 生成分享链接
+ */
+func (info *ShareInfo) Link() (string, error) {
+	addr := net.JoinHostPort(info.Address, strconv.Itoa(int(info.Port)))
+	switch info.Protocol {
+	case "vless":
+		query := info.query()
+		query.Set("encryption", "none")
+		if info.Flow != "" {
+			query.Set("flow", info.Flow)
+		}
+		return info.uri("vless", url.User(info.Id), addr, query), nil
+	case "trojan":
+		return info.uri("trojan", url.User(info.Password), addr, info.query()), nil
+	case "vmess":
+		vmess := map[string]string{
+			"v": "2", "ps": info.Remark, "add": info.Address, "port": strconv.Itoa(int(info.Port)),
+			"id": info.Id, "aid": "0", "scy": "auto", "net": info.Network, "type": info.HeaderType,
+			"host": info.Host, "path": info.Path, "tls": "", "sni": info.Sni, "fp": info.Fingerprint,
+			"alpn": strings.Join(info.Alpn, ","),
+		}
+		if vmess["type"] == "" {
+			vmess["type"] = "none"
+		}
+		switch info.Network {
+		case "grpc":
+			vmess["path"], vmess["type"] = info.ServiceName, info.Mode
+		case "kcp":
+			vmess["path"] = info.Seed
+		case "xhttp":
+			vmess["type"] = info.Mode
+		}
+		if info.Security == "tls" {
+			vmess["tls"] = "tls"
+		} else if info.Security == "reality" {
+			return "", errors.New("vmess 不支持 reality")
+		}
+		bts, _ := json.Marshal(vmess)
+		return "vmess://" + base64.StdEncoding.EncodeToString(bts), nil
+	case "shadowsocks":
+		if info.Network != "tcp" || info.Security != "none" {
+			return "", errors.New("shadowsocks 链接不支持传输层配置")
+		}
+		// SIP002, 2022 方法使用 url 编码
+		user := base64.RawURLEncoding.EncodeToString([]byte(info.Method + ":" + info.Password))
+		if strings.HasPrefix(info.Method, "2022-") {
+			user = url.QueryEscape(info.Method) + ":" + url.QueryEscape(info.Password)
+		}
+		return fmt.Sprintf("ss://%s@%s#%s", user, addr, url.PathEscape(info.Remark)), nil
+	}
+	return "", errors.New("不支持分享的协议: " + info.Protocol)
+}
+
+func (info *ShareInfo) query() url.Values {
+	query := url.Values{}
+	query.Set("type", info.Network)
+	query.Set("security", info.Security)
+	set := func(key, val string) {
+		if val != "" {
+			query.Set(key, val)
+		}
+	}
+	set("headerType", info.HeaderType)
+	set("path", info.Path)
+	set("host", info.Host)
+	set("serviceName", info.ServiceName)
+	set("mode", info.Mode)
+	set("seed", info.Seed)
+	set("sni", info.Sni)
+	set("fp", info.Fingerprint)
+	set("alpn", strings.Join(info.Alpn, ","))
+	set("pbk", info.PublicKey)
+	set("sid", info.ShortId)
+	set("spx", info.SpiderX)
+	if info.Insecure {
+		query.Set("allowInsecure", "1")
+	}
+	return query
+}
+
+func (info *ShareInfo) uri(scheme string, user *url.Userinfo, addr string, query url.Values) string {
+	uri := url.URL{Scheme: scheme, User: user, Host: addr, RawQuery: query.Encode(), Fragment: info.Remark}
+	return uri.String()
+}
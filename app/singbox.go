@@ -0,0 +1,106 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/**
+ * 转换为 sing-box 出站
+ */
+func SingBoxOutbound(info *ShareInfo) (map[string]any, error) {
+	outbound := map[string]any{
+		"tag":         info.Remark,
+		"server":      info.Address,
+		"server_port": info.Port,
+	}
+	switch info.Protocol {
+	case "vless":
+		outbound["type"] = "vless"
+		outbound["uuid"] = info.Id
+		outbound["packet_encoding"] = "xudp"
+		if info.Flow != "" {
+			outbound["flow"] = info.Flow
+		}
+	case "vmess":
+		outbound["type"] = "vmess"
+		outbound["uuid"] = info.Id
+		outbound["security"] = "auto"
+		outbound["alter_id"] = 0
+	case "trojan":
+		outbound["type"] = "trojan"
+		outbound["password"] = info.Password
+	case "shadowsocks":
+		outbound["type"] = "shadowsocks"
+		outbound["method"] = info.Method
+		outbound["password"] = info.Password
+	default:
+		return nil, errors.New("不支持的协议: " + info.Protocol)
+	}
+	// 传输
+	switch info.Network {
+	case "tcp":
+		if info.HeaderType != "" && info.HeaderType != "none" {
+			return nil, errors.New("不支持 tcp 伪装: " + info.HeaderType)
+		}
+	case "ws", "httpupgrade":
+		transport := map[string]any{"type": info.Network, "path": info.Path}
+		if info.Host != "" && info.Network == "ws" {
+			transport["headers"] = map[string]string{"Host": info.Host}
+		} else if info.Host != "" {
+			transport["host"] = info.Host
+		}
+		outbound["transport"] = transport
+	case "grpc":
+		outbound["transport"] = map[string]any{"type": "grpc", "service_name": info.ServiceName}
+	default:
+		return nil, errors.New("不支持的传输: " + info.Network)
+	}
+	if info.Protocol == "shadowsocks" && (info.Network != "tcp" || info.Security != "none") {
+		return nil, errors.New("shadowsocks 不支持传输层配置")
+	}
+	// 安全
+	switch info.Security {
+	case "tls", "reality":
+		tls := map[string]any{"enabled": true}
+		if info.Sni != "" {
+			tls["server_name"] = info.Sni
+		}
+		if len(info.Alpn) > 0 {
+			tls["alpn"] = info.Alpn
+		}
+		if info.Fingerprint != "" {
+			tls["utls"] = map[string]any{"enabled": true, "fingerprint": info.Fingerprint}
+		}
+		if info.Security == "reality" {
+			tls["reality"] = map[string]any{"enabled": true, "public_key": info.PublicKey, "short_id": info.ShortId}
+		}
+		outbound["tls"] = tls
+	}
+	return outbound, nil
+}
+
+/**
+ * 生成 sing-box 出站配置, 包含 selector, 返回无法转换的条目
+ */
+func SingBoxProfile(infos []*ShareInfo) ([]byte, []string, error) {
+	outbounds, tags, errs := []any{}, []string{}, []string{}
+	for _, info := range infos {
+		outbound, err := SingBoxOutbound(info)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", info.Remark, err.Error()))
+			continue
+		}
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, info.Remark)
+	}
+	selector := map[string]any{"type": "selector", "tag": "proxy", "outbounds": append(tags, "direct")}
+	if len(tags) > 0 {
+		selector["default"] = tags[0]
+	}
+	outbounds = append([]any{selector}, outbounds...)
+	outbounds = append(outbounds, map[string]any{"type": "direct", "tag": "direct"})
+	bts, err := json.MarshalIndent(map[string]any{"outbounds": outbounds}, "", "  ")
+	return bts, errs, err
+}
@@ -0,0 +1,316 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * 用户订阅, 路径 /sub/<token>?format=base64|clash|singbox, 不使用 API 令牌验证
+ * format=png|svg 返回分享链接二维码, tag 指定 inbound, 为空使用第一个, size, level 同 GetQrCode
+ * token 由订阅密钥, 用户 email 和用户 nonce 计算, 轮换 nonce 使该用户的旧 token 失效
+ */
+type Subscribe struct {
+	Key  string // 订阅密钥, 为空不提供订阅
+	Host string // 服务的公网地址, 为空使用请求的 Host
+	Path string // 订阅路径前缀
+	File string // 用户 nonce 存储文件
+
+	lock   sync.RWMutex
+	nonces map[string]string // email -> nonce, 未轮换的用户没有记录, 已吊销为 SubRevoked
+}
+
+/**
+ * 已吊销的订阅
+ */
+const SubRevoked = "-"
+
+func (this *Subscribe) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.nonces = map[string]string{}
+	if this.File == "" {
+		return nil
+	}
+	bts, err := os.ReadFile(this.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(bts, &this.nonces)
+}
+
+func (this *Subscribe) save() error {
+	if this.File == "" {
+		return nil
+	}
+	bts, err := json.MarshalIndent(this.nonces, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := this.File + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.File)
+}
+
+func (this *Subscribe) nonce(email string) string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.nonces[email]
+}
+
+/**
+ * 用户的订阅 token, 已吊销返回错误
+ */
+func (this *Subscribe) Token(email string) (string, error) {
+	nonce := this.nonce(email)
+	if nonce == SubRevoked {
+		return "", errors.New("订阅已吊销: " + email)
+	}
+	return this.token(email, nonce), nil
+}
+
+func (this *Subscribe) token(email, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(this.Key))
+	mac.Write([]byte(email))
+	if nonce != "" {
+		mac.Write([]byte{0})
+		mac.Write([]byte(nonce))
+	}
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:24]
+}
+
+/**
+ * 轮换用户的 nonce, 旧 token 失效, 已吊销的订阅重新启用
+ */
+func (this *Subscribe) Rotate(email string) (string, error) {
+	bts := make([]byte, 12)
+	if _, err := rand.Read(bts); err != nil {
+		return "", err
+	}
+	nonce := hex.EncodeToString(bts)
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.nonces == nil {
+		this.nonces = map[string]string{}
+	}
+	this.nonces[email] = nonce
+	if err := this.save(); err != nil {
+		return "", err
+	}
+	return this.token(email, nonce), nil
+}
+
+/**
+ * 吊销用户的订阅, 轮换后恢复
+ */
+func (this *Subscribe) Revoke(email string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.nonces == nil {
+		this.nonces = map[string]string{}
+	}
+	this.nonces[email] = SubRevoked
+	return this.save()
+}
+
+/**
+ * 根据 token 查找用户
+ */
+func (this *Subscribe) Lookup(serve *XrayServe, token string) (string, error) {
+	for _, email := range serve.LstUsers() {
+		if sub, err := this.Token(email); err != nil {
+			continue
+		} else if subtle.ConstantTimeCompare([]byte(sub), []byte(token)) == 1 {
+			return email, nil
+		}
+	}
+	return "", errors.New("无效的订阅")
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 列出配置中全部用户的 email
+ */
+func (this *XrayServe) LstUsers() []string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	data := []string{}
+	if this.Xconf == nil {
+		return data
+	}
+	found := map[string]bool{}
+	for idx := range this.Xconf.InboundConfigs {
+		clients, _ := InboundClients(&this.Xconf.InboundConfigs[idx])
+		for _, cln := range clients {
+			if email, _ := cln["email"].(string); email != "" && !found[email] {
+				found[email] = true
+				data = append(data, email)
+			}
+		}
+	}
+	return data
+}
+
+/**
+ * 获取用户所在全部 inbound 的分享信息, 无法分享的 inbound 记录在错误中
+ */
+func (this *XrayServe) UserShareInfos(email, host string) ([]*ShareInfo, []string) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	infos, errs := []*ShareInfo{}, []string{}
+	if this.Xconf == nil {
+		return infos, errs
+	}
+	for idx := range this.Xconf.InboundConfigs {
+		cinb := &this.Xconf.InboundConfigs[idx]
+		clients, _ := InboundClients(cinb)
+		for _, cln := range clients {
+			if cln["email"] != email {
+				continue
+			}
+			if info, err := NewShareInfo(cinb, email, host); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", cinb.Tag, err.Error()))
+			} else {
+				infos = append(infos, info)
+			}
+			break
+		}
+	}
+	return infos, errs
+}
+
+/**
+ * 用户流量, 优先使用流量历史, 其次使用 Xray 计数器
+ */
+func (this *Worker) UserTraffic(email string) (int64, int64) {
+	if this.Traffic.Interval > 0 {
+		if pts, err := this.Traffic.Query("user", email, "day", time.Unix(0, 0), time.Now()); err == nil && len(pts) > 0 {
+			up, down := int64(0), int64(0)
+			for _, pt := range pts {
+				up, down = up+pt.Uplink, down+pt.Downlink
+			}
+			return up, down
+		}
+	}
+	mng, err := this.Serve.StatsManager()
+	if err != nil {
+		return 0, 0
+	}
+	up, down := int64(0), int64(0)
+	if ctr := mng.GetCounter("user>>>" + email + ">>>traffic>>>uplink"); ctr != nil {
+		up = ctr.Value()
+	}
+	if ctr := mng.GetCounter("user>>>" + email + ">>>traffic>>>downlink"); ctr != nil {
+		down = ctr.Value()
+	}
+	return up, down
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 订阅处理
+ */
+func (this *Worker) subscribe(ww http.ResponseWriter, rr *http.Request) {
+	token := strings.TrimPrefix(rr.URL.Path, this.Subscribe.Path)
+	email, err := this.Subscribe.Lookup(&this.Serve, token)
+	if err != nil {
+		http.Error(ww, err.Error(), http.StatusNotFound)
+		return
+	}
+	host := this.Subscribe.Host
+	if host == "" {
+		if host, _, err = net.SplitHostPort(rr.Host); err != nil {
+			host = rr.Host
+		}
+	}
+	infos, errs := this.Serve.UserShareInfos(email, host)
+	// 订阅格式, 默认 base64 链接
+	var body []byte
+	ctype := "text/plain; charset=utf-8"
+	switch format := rr.URL.Query().Get("format"); format {
+	case "singbox":
+		bts, errs_, err := SingBoxProfile(infos)
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, errs, ctype = bts, append(errs, errs_...), "application/json; charset=utf-8"
+	case "clash":
+		bts, errs_, err := ClashProfile(infos)
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body, errs, ctype = bts, append(errs, errs_...), "text/yaml; charset=utf-8"
+	case "", "base64":
+		links := []string{}
+		for _, info := range infos {
+			if link, err := info.Link(); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", info.Remark, err.Error()))
+			} else {
+				links = append(links, link)
+			}
+		}
+		body = []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(links, "\n"))))
+	case "png", "svg":
+		query := rr.URL.Query()
+		opt, err := NewQrOption(format, query.Get("size"), query.Get("level"))
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusBadRequest)
+			return
+		}
+		info := (*ShareInfo)(nil)
+		if tag := query.Get("tag"); tag != "" {
+			info, err = this.Serve.ShareInfo(tag, email, host)
+		} else if len(infos) > 0 {
+			info = infos[0]
+		} else {
+			err = errors.New("没有可分享的 inbound")
+		}
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusNotFound)
+			return
+		}
+		link, err := info.Link()
+		if err != nil {
+			http.Error(ww, err.Error(), http.StatusNotFound)
+			return
+		}
+		if body, ctype, err = opt.Encode(link); err != nil {
+			http.Error(ww, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(ww, "无效的订阅格式: "+format, http.StatusBadRequest)
+		return
+	}
+	for _, msg := range errs {
+		fmt.Printf("订阅跳过 inbound: %s, %s\n", email, msg)
+	}
+	up, down := this.UserTraffic(email)
+	ww.Header().Set("Content-Type", ctype)
+	// 没有流量配额和到期时间, 不返回 total 和 expire, 客户端视为不限
+	ww.Header().Set("Subscription-Userinfo", fmt.Sprintf("upload=%d; download=%d", up, down))
+	ww.Header().Set("Profile-Update-Interval", "24")
+	ww.Write(body)
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/**
+ * API 服务 TLS, 证书文件修改后自动重新加载
+ * 配置客户端 CA 时启用 mTLS, 客户端证书的 CN 作为认证主体
+ */
+type ApiTls struct {
+	CertFile string   // 证书文件
+	KeyFile  string   // 私钥文件
+	ClientCA string   // 客户端 CA 文件, 为空不验证客户端证书
+	Required bool     // 是否要求客户端证书
+	Scopes   []string // 客户端证书的权限
+
+	lock  sync.Mutex
+	cert  *tls.Certificate
+	mtime time.Time
+	check time.Time
+}
+
+func (this *ApiTls) Enabled() bool {
+	return this.CertFile != "" && this.KeyFile != ""
+}
+
+/**
+ * 获取证书, 最多每 10 秒检查一次文件是否修改
+ */
+func (this *ApiTls) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	now := time.Now()
+	if this.cert != nil && now.Sub(this.check) < 10*time.Second {
+		return this.cert, nil
+	}
+	this.check = now
+	if err := this.load(); err != nil {
+		if this.cert != nil {
+			fmt.Printf("重新加载 API 证书失败: %s\n", err.Error())
+			return this.cert, nil
+		}
+		return nil, err
+	}
+	return this.cert, nil
+}
+
+func (this *ApiTls) load() error {
+	cstat, err := os.Stat(this.CertFile)
+	if err != nil {
+		return err
+	}
+	kstat, err := os.Stat(this.KeyFile)
+	if err != nil {
+		return err
+	}
+	mtime := cstat.ModTime()
+	if kstat.ModTime().After(mtime) {
+		mtime = kstat.ModTime()
+	}
+	if this.cert != nil && mtime.Equal(this.mtime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(this.CertFile, this.KeyFile)
+	if err != nil {
+		return err
+	}
+	if this.cert != nil {
+		fmt.Println("API 证书已重新加载")
+	}
+	this.cert, this.mtime = &cert, mtime
+	return nil
+}
+
+/**
+ * 创建服务端 TLS 配置
+ */
+func (this *ApiTls) Config() (*tls.Config, error) {
+	this.lock.Lock()
+	err := this.load()
+	this.lock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: this.GetCertificate}
+	if this.ClientCA != "" {
+		bts, err := os.ReadFile(this.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bts) {
+			return nil, errors.New("无效的客户端 CA: " + this.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if this.Required {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
+/**
+ * 客户端证书主体, 没有经过验证的证书返回 nil
+ */
+func (this *ApiTls) Principal(rr *http.Request) *Principal {
+	if this.ClientCA == "" || rr.TLS == nil || len(rr.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	cert := rr.TLS.VerifiedChains[0][0]
+	return &Principal{Kind: "cert", Id: cert.Subject.CommonName, Scopes: this.Scopes}
+}
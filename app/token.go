@@ -0,0 +1,630 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * API 令牌, 只保存令牌的 SHA-256 摘要
+ * 权限(scopes):
+ * admin            全部权限, 包括令牌管理
+ * read             只读操作(Lst*, Get*, Qry*, healthz, metrics)
+ * action:<pattern> 允许的操作, 支持通配符, 如 action:xray.*.LstStats
+ * tag:<prefix>     只能操作指定前缀的 tag, 不区分 tag 的操作被拒绝, 列表按前缀过滤
+ * reveal-secrets   查看凭据, 否则响应中的凭据被脱敏, 导出分享链接等操作被拒绝
+ */
+type ApiToken struct {
+	Id          string   `json:"id"`
+	Hash        string   `json:"hash,omitempty"`
+	Sign        string   `json:"sign,omitempty"` // HMAC 签名密钥, 加密保存
+	Description string   `json:"description,omitempty"`
+	Expire      string   `json:"expire,omitempty"` // RFC3339, 为空不过期
+	Scopes      []string `json:"scopes"`
+	Created     string   `json:"created"`
+}
+
+/**
+ * 请求的认证主体
+ */
+type Principal struct {
+	Kind   string   `json:"kind"` // none, token, jwt, cert, peer
+	Id     string   `json:"id,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+type principalKey struct{}
+
+/**
+ * 获取请求的认证主体
+ */
+func RequestPrincipal(rr *http.Request) *Principal {
+	if pp, ok := rr.Context().Value(principalKey{}).(*Principal); ok {
+		return pp
+	}
+	return &Principal{Kind: "none"}
+}
+
+func WithPrincipal(rr *http.Request, pp *Principal) *http.Request {
+	return rr.WithContext(context.WithValue(rr.Context(), principalKey{}, pp))
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 令牌库, 签名密钥使用配置文件密钥加密, 配置了存储文件时必须配置密钥
+ */
+type TokenStore struct {
+	File  string      // 存储文件, 为空不启用
+	Crypt *ConfCipher // 签名密钥加密
+
+	lock   sync.RWMutex
+	tokens map[string]*ApiToken
+}
+
+func (this *TokenStore) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.tokens = map[string]*ApiToken{}
+	if this.File == "" {
+		return nil
+	}
+	if this.Crypt == nil {
+		return errors.New("令牌库需要密钥加密签名密钥(-conf-key 或环境变量 XRAYW_CONF_KEY)")
+	}
+	if key, err := LoadConfKey(this.File+".key", ""); err == nil {
+		// 旧版本在令牌库旁生成的签名密钥, 加载后使用配置文件密钥重新加密, 之后可以删除该文件
+		fmt.Printf("警告: 令牌库密钥文件 %s 已弃用, 重新加密后请删除\n", this.File+".key")
+		crypt := NewConfCipher(this.Crypt.Keys[this.Crypt.Current], key)
+		for kid, old := range this.Crypt.Keys {
+			crypt.Keys[kid] = old
+		}
+		this.Crypt = crypt
+	}
+	bts, err := os.ReadFile(this.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := json.Unmarshal(bts, &this.tokens); err != nil {
+		return err
+	}
+	return this.reseal()
+}
+
+/**
+ * 使用旧密钥加密的签名密钥用当前密钥重新加密, 用于密钥轮换
+ */
+func (this *TokenStore) reseal() error {
+	changed := false
+	for _, token := range this.tokens {
+		sealed, err := base64.StdEncoding.DecodeString(token.Sign)
+		if err != nil || !IsEncrypted(sealed) {
+			continue
+		}
+		if kid, _, ok := cryptHead(sealed); !ok || kid == this.Crypt.Current {
+			continue
+		}
+		sign, err := this.Crypt.Open(sealed)
+		if err != nil {
+			return errors.New(token.Id + ": " + err.Error())
+		}
+		if sealed, err = this.Crypt.Seal(sign); err != nil {
+			return err
+		}
+		token.Sign, changed = base64.StdEncoding.EncodeToString(sealed), true
+	}
+	if !changed {
+		return nil
+	}
+	return this.save()
+}
+
+func (this *TokenStore) Save() error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.save()
+}
+
+func (this *TokenStore) save() error {
+	if this.File == "" {
+		return nil
+	}
+	bts, err := json.MarshalIndent(this.tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := this.File + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.File)
+}
+
+/**
+ * 是否存在令牌
+ */
+func (this *TokenStore) Enabled() bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return len(this.tokens) > 0
+}
+
+/**
+ * 创建令牌, 返回令牌明文和 HMAC 签名密钥, 只在创建时返回
+ */
+func (this *TokenStore) Add(id, desc, expire string, scopes []string) (*ApiToken, string, string, error) {
+	if this.File == "" {
+		return nil, "", "", errors.New("未配置令牌库文件(-token-file)")
+	}
+	if id == "" {
+		return nil, "", "", errors.New("无效的 id")
+	}
+	if expire != "" {
+		if _, err := time.Parse(time.RFC3339, expire); err != nil {
+			return nil, "", "", errors.New("无效的 expire: " + expire)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "", "", errors.New("无效的 scopes")
+	}
+	for _, scope := range scopes {
+		if err := CheckScope(scope); err != nil {
+			return nil, "", "", err
+		}
+	}
+	bts := make([]byte, 48)
+	if _, err := rand.Read(bts); err != nil {
+		return nil, "", "", err
+	}
+	secret := "xw_" + base64.RawURLEncoding.EncodeToString(bts[:24])
+	sign := "xws_" + base64.RawURLEncoding.EncodeToString(bts[24:])
+	sealed, err := this.Crypt.Seal([]byte(sign))
+	if err != nil {
+		return nil, "", "", err
+	}
+	token := &ApiToken{
+		Id: id, Hash: TokenHash(secret), Sign: base64.StdEncoding.EncodeToString(sealed),
+		Description: desc, Expire: expire, Scopes: scopes, Created: time.Now().Format(time.RFC3339),
+	}
+	this.lock.Lock()
+	if this.tokens == nil {
+		this.tokens = map[string]*ApiToken{}
+	}
+	if _, ok := this.tokens[id]; ok {
+		this.lock.Unlock()
+		return nil, "", "", errors.New("令牌已存在: " + id)
+	}
+	this.tokens[id] = token
+	this.lock.Unlock()
+	return token, secret, sign, this.Save()
+}
+
+/**
+ * 解密令牌的 HMAC 签名密钥
+ */
+func (this *TokenStore) SignKey(token *ApiToken) ([]byte, error) {
+	if token.Sign == "" {
+		return nil, errors.New("令牌不支持签名, 请重新创建: " + token.Id)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(token.Sign)
+	if err != nil {
+		return nil, errors.New("无效的签名密钥: " + token.Id)
+	}
+	if this.Crypt == nil || !IsEncrypted(sealed) {
+		return nil, errors.New("签名密钥未加密: " + token.Id)
+	}
+	return this.Crypt.Open(sealed)
+}
+
+func (this *TokenStore) Del(id string) error {
+	this.lock.Lock()
+	if _, ok := this.tokens[id]; !ok {
+		this.lock.Unlock()
+		return errors.New("令牌未找到: " + id)
+	}
+	delete(this.tokens, id)
+	this.lock.Unlock()
+	return this.Save()
+}
+
+func (this *TokenStore) List() []*ApiToken {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	data := []*ApiToken{}
+	for _, token := range this.tokens {
+		cp := *token
+		cp.Hash, cp.Sign = "", ""
+		data = append(data, &cp)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Id < data[j].Id })
+	return data
+}
+
+/**
+ * 根据令牌明文查找, 检查是否过期
+ */
+func (this *TokenStore) Lookup(secret string, now time.Time) (*ApiToken, error) {
+	hash := TokenHash(secret)
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	for _, token := range this.tokens {
+		if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hash)) != 1 {
+			continue
+		}
+		if token.Expired(now) {
+			return nil, errors.New("令牌已过期")
+		}
+		return token, nil
+	}
+	return nil, errors.New("无效的令牌")
+}
+
+func (this *ApiToken) Expired(now time.Time) bool {
+	if this.Expire == "" {
+		return false
+	}
+	expire, err := time.Parse(time.RFC3339, this.Expire)
+	return err == nil && now.After(expire)
+}
+
+func TokenHash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+/**
+ * 主体名称, 用于日志和事件
+ */
+func (this *Principal) String() string {
+	if this.Kind == "none" {
+		return "none"
+	}
+	return this.Kind + ":" + this.Id
+}
+
+/**
+ * 验证请求令牌, 未配置任何令牌时跳过验证
+ * -token 配置的令牌拥有 admin 权限, 没有 Authorization 时使用客户端证书或对端身份
+ */
+func (this *Worker) authorize(rr *http.Request) (*Principal, *Result) {
+	auth := rr.Header.Get("Authorization")
+	peer, perr := this.Unix.Principal(rr)
+	if auth == "" {
+		// 客户端证书, unix socket 对端身份
+		if pp := this.Tls.Principal(rr); pp != nil {
+			return pp, nil
+		}
+		if peer != nil {
+			return peer, nil
+		}
+	}
+	if this.Token == "" && !this.Tokens.Enabled() && !this.Jwt.Enabled() {
+		if perr != nil {
+			// 限制了对端身份, 不匹配的对端不能按未认证访问
+			return nil, &Result{ErrCode: "invalid_token", Message: perr.Error()}
+		}
+		return &Principal{Kind: "none"}, nil
+	}
+	if auth == "" && perr != nil {
+		return nil, &Result{ErrCode: "invalid_token", Message: perr.Error()}
+	}
+	if sign, ok := strings.CutPrefix(auth, "HMAC-SHA256 "); ok {
+		return this.authorizeHmac(rr, sign)
+	}
+	if bearer, ok := strings.CutPrefix(auth, "Bearer "); ok && this.Jwt.Enabled() {
+		claims, err := this.Jwt.Verify(bearer, time.Now())
+		if err != nil {
+			return nil, &Result{ErrCode: "invalid_token", Message: err.Error()}
+		}
+		return &Principal{Kind: "jwt", Id: claims.Subject, Scopes: claims.ToScopes()}, nil
+	}
+	secret, ok := strings.CutPrefix(auth, "Token ")
+	if !ok || secret == "" {
+		return nil, &Result{ErrCode: "invalid_token", Message: "无效的令牌"}
+	}
+	if this.Token != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(this.Token)) == 1 {
+		return &Principal{Kind: "token", Id: "default", Scopes: []string{"admin"}}, nil
+	}
+	token, err := this.Tokens.Lookup(secret, time.Now())
+	if err != nil {
+		return nil, &Result{ErrCode: "invalid_token", Message: err.Error()}
+	}
+	return &Principal{Kind: "token", Id: token.Id, Scopes: token.Scopes}, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 校验权限格式
+ */
+func CheckScope(scope string) error {
+	switch {
+	case scope == "admin", scope == "read", scope == "reveal-secrets":
+		return nil
+	case strings.HasPrefix(scope, "action:"):
+		if _, err := path.Match(scope[len("action:"):], ""); err != nil {
+			return errors.New("无效的 scope: " + scope)
+		}
+		return nil
+	case strings.HasPrefix(scope, "tag:") && len(scope) > len("tag:"):
+		return nil
+	}
+	return errors.New("无效的 scope: " + scope)
+}
+
+/**
+ * 解析逗号分隔的权限
+ */
+func ParseScopes(text string) ([]string, error) {
+	scopes := []string{}
+	for _, scope := range strings.Split(text, ",") {
+		if scope = strings.TrimSpace(scope); scope == "" {
+			continue
+		} else if err := CheckScope(scope); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+/**
+ * 是否为只读操作
+ */
+func IsReadAction(action string) bool {
+	if action == "healthz" || action == "metrics" {
+		return true
+	}
+	name := action[strings.LastIndexByte(action, '.')+1:]
+	return strings.HasPrefix(name, "Lst") || strings.HasPrefix(name, "Get") || strings.HasPrefix(name, "Qry")
+}
+
+/**
+ * tag 权限(tag:<prefix>)允许的操作, 需要在请求中指定 tag
+ * TagLists 为按前缀过滤结果的列表操作, healthz 和生成类操作不涉及 tag
+ * 其余操作不区分 tag, 对 tag 权限的主体拒绝
+ */
+var TagActions = []string{
+	"AddInbound", "DelInbound", "AddOutbound", "DelOutbound", "AddRoute", "DelRoute", "AddIObound", "DelIObound",
+	"AddUser", "DelUser", "GetShareLink", "GetQrCode", "GetClientConf", "ImportOutbound",
+	"AddRemote", "DelRemote", "UpdRemote", "LstOnline",
+}
+var TagLists = []string{"LstInbound", "LstOutbound", "LstRoute"}
+
+func actionIn(action string, names []string) bool {
+	name := action[strings.LastIndexByte(action, '.')+1:]
+	for _, item := range names {
+		if name == item {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * tag 权限的前缀, 为空不限制 tag
+ */
+func (this *Principal) TagPrefixes() []string {
+	prefixes := []string{}
+	for _, scope := range this.Scopes {
+		if strings.HasPrefix(scope, "tag:") {
+			prefixes = append(prefixes, scope[len("tag:"):])
+		}
+	}
+	return prefixes
+}
+
+/**
+ * 按 tag 权限过滤列表结果
+ */
+func (this *Principal) FilterTags(data []any) []any {
+	prefixes := this.TagPrefixes()
+	if len(prefixes) == 0 {
+		return data
+	}
+	found := []any{}
+	for _, itm := range data {
+		switch val := itm.(type) {
+		case string:
+			if MatchTag(prefixes, val) {
+				found = append(found, itm)
+			}
+		case *InboundOnline:
+			if MatchTag(prefixes, val.Tag) {
+				found = append(found, itm)
+			}
+		}
+	}
+	return found
+}
+
+func MatchTag(prefixes []string, tag string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(tag, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * 检查主体是否允许执行操作, tags 为请求中涉及的 tag
+ */
+func (this *Principal) Allow(action string, tags []string) error {
+	if this.Kind == "none" {
+		return nil
+	}
+	actions, prefixes, admin, read := []string{}, []string{}, false, false
+	for _, scope := range this.Scopes {
+		switch {
+		case scope == "admin":
+			admin = true
+		case scope == "read":
+			read = true
+		case strings.HasPrefix(scope, "action:"):
+			actions = append(actions, scope[len("action:"):])
+		case strings.HasPrefix(scope, "tag:"):
+			prefixes = append(prefixes, scope[len("tag:"):])
+		}
+	}
+	if strings.HasPrefix(action, "xray.token.") && !admin {
+		return errors.New("需要 admin 权限")
+	}
+	if IsSecretAction(action) && !this.Reveal() {
+		return errors.New("需要 reveal-secrets 权限")
+	}
+	if !admin && !read && len(actions) == 0 {
+		return errors.New("没有操作权限")
+	}
+	if read && !admin && !IsReadAction(action) {
+		return errors.New("只读令牌: " + action)
+	}
+	if len(actions) > 0 {
+		found := false
+		for _, pattern := range actions {
+			if ok, _ := path.Match(pattern, action); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return errors.New("不允许的操作: " + action)
+		}
+	}
+	if len(prefixes) > 0 && action != "healthz" && !strings.HasPrefix(action, "xray.gen.") && !actionIn(action, TagLists) {
+		if !actionIn(action, TagActions) {
+			return errors.New("tag 权限不允许的操作: " + action)
+		}
+		if len(tags) == 0 {
+			return errors.New("需要指定 tag")
+		}
+		for _, tag := range tags {
+			if !MatchTag(prefixes, tag) {
+				return errors.New("不允许的 tag: " + tag)
+			}
+		}
+	}
+	return nil
+}
+
+/**
+ * 获取请求涉及的 tag, 包括 query 中的 tag/prefix 和 JSON 请求体中的 tag
+ * 请求体中解析 tag, ruleTag, 路由规则的 inboundTag, outboundTag, balancerTag, 以及 inbound/outbound 的 tag
+ * 类型无效的 tag 字段原样返回, 不会匹配任何前缀
+ * 读取请求体后会重新设置, 不影响后续处理
+ */
+func RequestTags(rr *http.Request) []string {
+	tags := []string{}
+	query := rr.URL.Query()
+	for _, key := range []string{"tag", "prefix"} {
+		if val := query.Get(key); val != "" {
+			tags = append(tags, val)
+		}
+	}
+	if rr.Body == nil || rr.Method != http.MethodPost {
+		return tags
+	}
+	body, err := io.ReadAll(rr.Body)
+	rr.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return tags
+	}
+	return append(tags, bodyTags(body)...)
+}
+
+func bodyTags(body []byte) []string {
+	xcc := map[string]json.RawMessage{}
+	if json.Unmarshal(body, &xcc) != nil {
+		return nil // 不是 JSON 对象, 如分享链接列表
+	}
+	tags := []string{}
+	for _, key := range []string{"tag", "ruleTag", "inboundTag", "outboundTag", "balancerTag"} {
+		raw, ok := xcc[key]
+		if !ok {
+			continue
+		}
+		var one string
+		var list []string
+		if json.Unmarshal(raw, &one) == nil {
+			list = []string{one}
+		} else if json.Unmarshal(raw, &list) != nil {
+			list = []string{string(raw)}
+		}
+		for _, tag := range list {
+			if tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	for _, key := range []string{"inbound", "outbound"} {
+		if raw, ok := xcc[key]; ok {
+			tags = append(tags, bodyTags(raw)...)
+		}
+	}
+	return tags
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ *
+ * 令牌管理, 需要 admin 权限
+ *
+ * 创建令牌, scopes 逗号分隔, expire 为 RFC3339, 令牌和 HMAC 签名密钥(sign)只在创建时返回
+ * xray.token.Add
+ * xray.token.Del
+ * xray.token.Lst
+ *
+ */
+func (this *Worker) tokenz(ac string, ww http.ResponseWriter, rr *http.Request) {
+	var resp *Result = nil
+	query := rr.URL.Query()
+
+	switch ac {
+	case "xray.token.Add":
+		scopes := []string{}
+		for _, scope := range strings.Split(query.Get("scopes"), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		if token, secret, sign, err := this.Tokens.Add(query.Get("id"), query.Get("description"), query.Get("expire"), scopes); err != nil {
+			resp = &Result{ErrCode: "error_add_token", Message: "错误: " + err.Error()}
+		} else {
+			cp := *token
+			cp.Hash, cp.Sign = "", ""
+			resp = &Result{Success: true, Data: map[string]any{"token": secret, "sign": sign, "info": &cp}}
+		}
+	case "xray.token.Del":
+		if err := this.Tokens.Del(query.Get("id")); err != nil {
+			resp = &Result{ErrCode: "error_del_token", Message: "错误: " + err.Error()}
+		} else {
+			resp = &Result{Success: true}
+		}
+	case "xray.token.Lst":
+		resp = &Result{Success: true, Data: this.Tokens.List()}
+	}
+	if resp == nil {
+		resp = &Result{ErrCode: "invalid_xray", Message: "无效的操作: " + ac}
+	}
+	Response(rr, ww, resp)
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenStoreKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "xray.json.tokens")
+	// 未配置密钥时拒绝加载, 不在令牌库旁生成密钥文件
+	if err := (&TokenStore{File: file}).Load(); err == nil {
+		t.Fatal("loaded without key")
+	}
+	if _, err := os.Stat(file + ".key"); !os.IsNotExist(err) {
+		t.Fatalf("key file created: %v", err)
+	}
+	// 未配置文件不启用, 不能创建令牌
+	if _, _, _, err := (&TokenStore{}).Add("a", "", "", []string{"admin"}); err == nil {
+		t.Fatal("token added without file")
+	}
+	store := &TokenStore{File: file, Crypt: NewConfCipher(testKey(t))}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	token, _, sign, err := store.Add("a", "", "", []string{"admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := store.SignKey(token); err != nil || string(key) != sign {
+		t.Fatalf("sign key: %q, %v", key, err)
+	}
+}
+
+type countReader struct{ read int }
+
+func (this *countReader) Read(buf []byte) (int, error) {
+	this.read += len(buf)
+	return 0, io.EOF
+}
+
+func TestAuthorizeBeforeBody(t *testing.T) {
+	worker := NewHandler()
+	worker.Token = "secret"
+	body := &countReader{}
+	rr := httptest.NewRequest("POST", "/?action=xray.app.proxyman.conf.AddInbound", body)
+	ww := httptest.NewRecorder()
+	worker.serveHTTP(ww, rr)
+	if !strings.Contains(ww.Body.String(), "invalid_token") {
+		t.Fatalf("response: %s", ww.Body.String())
+	}
+	// 未认证的请求不读取请求体
+	if body.read > 0 {
+		t.Fatalf("body read before authorize: %d", body.read)
+	}
+}
@@ -0,0 +1,280 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/app/stats"
+	feature_stats "github.com/xtls/xray-core/features/stats"
+)
+
+/**
+ * 流量统计单位及保留时长
+ */
+var TrafficUnits = []struct {
+	Unit   string
+	Step   time.Duration
+	Retain time.Duration
+}{
+	{"minute", time.Minute, 24 * time.Hour},
+	{"hour", time.Hour, 31 * 24 * time.Hour},
+	{"day", 24 * time.Hour, 366 * 24 * time.Hour},
+}
+
+/**
+ * 流量统计点
+ */
+type TrafficPoint struct {
+	Time     int64 `json:"time"`
+	Uplink   int64 `json:"uplink"`
+	Downlink int64 `json:"downlink"`
+}
+
+/**
+ * 流量历史存储, 定时采样 Xray 计数器, 按 分钟/小时/天 汇总
+ * Series: (inbound|outbound|user)>>>name -> unit -> points
+ */
+type TrafficStore struct {
+	File     string        // 存储文件
+	Interval time.Duration // 采样间隔
+
+	Series map[string]map[string][]*TrafficPoint
+
+	lock sync.RWMutex
+	last map[string]int64 // 上次采样的计数器值
+	stop chan struct{}
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 获取 Xray 统计管理器
+ */
+func (this *XrayServe) StatsManager() (*stats.Manager, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if !this.isRunning() {
+		return nil, errors.New("Xray未启动")
+	}
+	mng, ok := this.XrayA.GetFeature(feature_stats.ManagerType()).(*stats.Manager)
+	if !ok {
+		return nil, errors.New("未启用统计功能, 请配置 stats")
+	}
+	return mng, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 加载流量历史
+ */
+func (this *TrafficStore) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.Series = map[string]map[string][]*TrafficPoint{}
+	this.last = map[string]int64{}
+	if this.File == "" {
+		return nil
+	}
+	bts, err := os.ReadFile(this.File)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(bts, &this.Series)
+}
+
+/**
+ * 保存流量历史
+ */
+func (this *TrafficStore) Save() error {
+	if this.File == "" {
+		return nil
+	}
+	this.lock.RLock()
+	bts, err := json.Marshal(this.Series)
+	this.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := this.File + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, this.File)
+}
+
+/**
+ * 启动采样
+ */
+func (this *TrafficStore) Start(serve *XrayServe) {
+	if this.Interval <= 0 {
+		return
+	}
+	if err := this.Load(); err != nil {
+		fmt.Printf("加载流量历史失败: %s\n", err.Error())
+	}
+	this.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(this.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-this.stop:
+				return
+			case now := <-ticker.C:
+				if mng, err := serve.StatsManager(); err == nil {
+					this.Sample(mng, now)
+					if err := this.Save(); err != nil {
+						fmt.Printf("保存流量历史失败: %s\n", err.Error())
+					}
+				}
+			}
+		}
+	}()
+}
+
+/**
+ * 停止采样
+ */
+func (this *TrafficStore) Close() {
+	if this.stop == nil {
+		return
+	}
+	close(this.stop)
+	this.stop = nil
+	if err := this.Save(); err != nil {
+		fmt.Printf("保存流量历史失败: %s\n", err.Error())
+	}
+}
+
+/**
+ * 采样计数器, 计数器是累计值, Xray 重启后会归零
+ */
+func (this *TrafficStore) Sample(mng *stats.Manager, now time.Time) {
+	delta := map[string]*TrafficPoint{}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.last == nil {
+		this.last = map[string]int64{}
+	}
+	if this.Series == nil {
+		this.Series = map[string]map[string][]*TrafficPoint{}
+	}
+	mng.VisitCounters(func(name string, ctr feature_stats.Counter) bool {
+		// inbound>>>tag>>>traffic>>>uplink
+		parts := strings.Split(name, ">>>")
+		if len(parts) != 4 || parts[2] != "traffic" {
+			return true
+		}
+		value := ctr.Value()
+		diff := value - this.last[name]
+		this.last[name] = value
+		if diff < 0 {
+			diff = value // 计数器已重置
+		}
+		if diff == 0 {
+			return true
+		}
+		key := parts[0] + ">>>" + parts[1]
+		pt, ok := delta[key]
+		if !ok {
+			pt = &TrafficPoint{}
+			delta[key] = pt
+		}
+		switch parts[3] {
+		case "uplink":
+			pt.Uplink += diff
+		case "downlink":
+			pt.Downlink += diff
+		}
+		return true
+	})
+	for key, pt := range delta {
+		this.add(key, pt.Uplink, pt.Downlink, now)
+	}
+	this.trim(now)
+}
+
+func (this *TrafficStore) add(key string, up, down int64, now time.Time) {
+	units, ok := this.Series[key]
+	if !ok {
+		units = map[string][]*TrafficPoint{}
+		this.Series[key] = units
+	}
+	for _, tu := range TrafficUnits {
+		step := int64(tu.Step / time.Second)
+		bucket := now.Unix() / step * step
+		pts := units[tu.Unit]
+		if n := len(pts); n > 0 && pts[n-1].Time == bucket {
+			pts[n-1].Uplink += up
+			pts[n-1].Downlink += down
+		} else {
+			units[tu.Unit] = append(pts, &TrafficPoint{Time: bucket, Uplink: up, Downlink: down})
+		}
+	}
+}
+
+func (this *TrafficStore) trim(now time.Time) {
+	for _, units := range this.Series {
+		for _, tu := range TrafficUnits {
+			pts := units[tu.Unit]
+			limit := now.Add(-tu.Retain).Unix()
+			idx := sort.Search(len(pts), func(i int) bool { return pts[i].Time >= limit })
+			if idx > 0 {
+				units[tu.Unit] = append([]*TrafficPoint{}, pts[idx:]...)
+			}
+		}
+	}
+}
+
+/**
+ * 查询流量历史
+ * kind: inbound | outbound | user
+ * unit: minute | hour | day
+ */
+func (this *TrafficStore) Query(kind, name, unit string, from, to time.Time) ([]*TrafficPoint, error) {
+	if kind != "inbound" && kind != "outbound" && kind != "user" {
+		return nil, errors.New("无效的类型: " + kind)
+	}
+	valid := false
+	for _, tu := range TrafficUnits {
+		valid = valid || tu.Unit == unit
+	}
+	if !valid {
+		return nil, errors.New("无效的单位: " + unit)
+	}
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	data := []*TrafficPoint{}
+	for _, pt := range this.Series[kind+">>>"+name][unit] {
+		if pt.Time >= from.Unix() && pt.Time <= to.Unix() {
+			data = append(data, &TrafficPoint{Time: pt.Time, Uplink: pt.Uplink, Downlink: pt.Downlink})
+		}
+	}
+	return data, nil
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 解析时间参数, 支持 unix 秒 和 RFC3339
+ */
+func ParseTime(val string, def time.Time) (time.Time, error) {
+	if val == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(val, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, val)
+}
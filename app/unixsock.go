@@ -0,0 +1,168 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Unix socket 监听, 本机代理无需开放 TCP 端口
+ * 配置 Uids/Gids 时, 通过 SO_PEERCRED 获取对端身份, 匹配的调用方无需令牌, 不匹配的调用方需要令牌
+ */
+type UnixSock struct {
+	Path   string   // socket 文件
+	Mode   string   // 文件权限, 八进制
+	Owner  string   // 文件所有者, user[:group]
+	Uids   []uint32 // 允许的对端 UID
+	Gids   []uint32 // 允许的对端 GID
+	Scopes []string // 对端的权限
+}
+
+/**
+ * 对端身份
+ */
+type PeerCred struct {
+	Pid int32
+	Uid uint32
+	Gid uint32
+}
+
+type peerKey struct{}
+
+func (this *UnixSock) Enabled() bool {
+	return this.Path != ""
+}
+
+/**
+ * 监听 socket, 删除残留的文件, 设置权限和所有者
+ */
+func (this *UnixSock) Listen() (net.Listener, error) {
+	if stat, err := os.Lstat(this.Path); err == nil {
+		if stat.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New("文件已存在且不是 socket: " + this.Path)
+		}
+		os.Remove(this.Path)
+	}
+	ln, err := net.Listen("unix", this.Path)
+	if err != nil {
+		return nil, err
+	}
+	if this.Mode != "" {
+		mode, err := strconv.ParseUint(this.Mode, 8, 32)
+		if err != nil {
+			ln.Close()
+			return nil, errors.New("无效的 socket 权限: " + this.Mode)
+		}
+		if err := os.Chmod(this.Path, os.FileMode(mode)); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if this.Owner != "" {
+		uid, gid, err := LookupOwner(this.Owner)
+		if err == nil {
+			err = os.Chown(this.Path, uid, gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	return ln, nil
+}
+
+/**
+ * 解析 user[:group], 支持名称或数字, 未指定的部分返回 -1
+ */
+func LookupOwner(owner string) (int, int, error) {
+	name, group, _ := strings.Cut(owner, ":")
+	uid, gid := -1, -1
+	if name != "" {
+		if id, err := strconv.Atoi(name); err == nil {
+			uid = id
+		} else if usr, err := user.Lookup(name); err != nil {
+			return 0, 0, err
+		} else {
+			uid, _ = strconv.Atoi(usr.Uid)
+		}
+	}
+	if group != "" {
+		if id, err := strconv.Atoi(group); err == nil {
+			gid = id
+		} else if grp, err := user.LookupGroup(group); err != nil {
+			return 0, 0, err
+		} else {
+			gid, _ = strconv.Atoi(grp.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+/**
+ * 解析逗号分隔的 ID 列表
+ */
+func ParseIds(text string) ([]uint32, error) {
+	ids := []uint32{}
+	for _, item := range strings.Split(text, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(item, 10, 32)
+		if err != nil {
+			return nil, errors.New("无效的 ID: " + item)
+		}
+		ids = append(ids, uint32(id))
+	}
+	return ids, nil
+}
+
+/**
+ * 连接上下文, 记录 unix 连接的对端身份, 获取失败时记录 nil
+ */
+func (this *UnixSock) ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := GetPeerCred(uc)
+	if err != nil {
+		fmt.Printf("获取对端身份失败: %s\n", err.Error())
+		cred = nil
+	}
+	return context.WithValue(ctx, peerKey{}, cred)
+}
+
+/**
+ * 对端主体, 未配置 Uids/Gids 或不是 unix 连接返回 nil
+ * 配置后 unix 连接的对端不匹配返回错误, 调用方不能再按未认证处理
+ */
+func (this *UnixSock) Principal(rr *http.Request) (*Principal, error) {
+	if len(this.Uids) == 0 && len(this.Gids) == 0 {
+		return nil, nil
+	}
+	cred, ok := rr.Context().Value(peerKey{}).(*PeerCred)
+	if !ok {
+		return nil, nil
+	}
+	if cred == nil {
+		return nil, errors.New("无法获取对端身份")
+	}
+	for _, uid := range this.Uids {
+		if uid == cred.Uid {
+			return &Principal{Kind: "peer", Id: fmt.Sprintf("uid:%d", cred.Uid), Scopes: this.Scopes}, nil
+		}
+	}
+	for _, gid := range this.Gids {
+		if gid == cred.Gid {
+			return &Principal{Kind: "peer", Id: fmt.Sprintf("gid:%d", cred.Gid), Scopes: this.Scopes}, nil
+		}
+	}
+	return nil, fmt.Errorf("不允许的对端: uid:%d, gid:%d", cred.Uid, cred.Gid)
+}
@@ -0,0 +1,28 @@
+package app
+
+import (
+	"net"
+	"syscall"
+)
+
+/**
+ * 通过 SO_PEERCRED 获取对端身份
+ */
+func GetPeerCred(conn *net.UnixConn) (*PeerCred, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		cred, serr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &PeerCred{Pid: cred.Pid, Uid: cred.Uid, Gid: cred.Gid}, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package app
+
+import (
+	"errors"
+	"net"
+)
+
+/**
+ * 仅 Linux 支持 SO_PEERCRED
+ */
+func GetPeerCred(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, errors.New("当前系统不支持 SO_PEERCRED")
+}
@@ -0,0 +1,197 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/features/inbound"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/proxy/shadowsocks"
+	"github.com/xtls/xray-core/proxy/shadowsocks_2022"
+	"github.com/xtls/xray-core/proxy/trojan"
+	vless_inbound "github.com/xtls/xray-core/proxy/vless/inbound"
+	vmess_inbound "github.com/xtls/xray-core/proxy/vmess/inbound"
+)
+
+/**
+ * 获取 inbound 配置中的用户
+ */
+func InboundClients(cinb *conf.InboundDetourConfig) ([]map[string]any, error) {
+	clients := []map[string]any{}
+	if cinb.Settings == nil {
+		return clients, nil
+	}
+	sets := map[string]json.RawMessage{}
+	if err := json.Unmarshal(*cinb.Settings, &sets); err != nil {
+		return nil, err
+	}
+	if raw, ok := sets["clients"]; ok {
+		if err := json.Unmarshal(raw, &clients); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+/**
+ * 替换 inbound 配置中的用户, 返回新的配置
+ */
+func InboundWithClients(cinb conf.InboundDetourConfig, clients []map[string]any) (conf.InboundDetourConfig, error) {
+	sets := map[string]json.RawMessage{}
+	if cinb.Settings != nil {
+		if err := json.Unmarshal(*cinb.Settings, &sets); err != nil {
+			return cinb, err
+		}
+	}
+	raw, err := json.Marshal(clients)
+	if err != nil {
+		return cinb, err
+	}
+	sets["clients"] = raw
+	bts, err := json.Marshal(sets)
+	if err != nil {
+		return cinb, err
+	}
+	rset := json.RawMessage(bts)
+	cinb.Settings = &rset
+	return cinb, nil
+}
+
+/**
+ * 从 inbound 配置中提取用户
+ */
+func InboundMemoryUsers(cinb conf.InboundDetourConfig) ([]*protocol.User, error) {
+	cinc, err := cinb.Build()
+	if err != nil {
+		return nil, err
+	}
+	inst, err := cinc.ProxySettings.GetInstance()
+	if err != nil {
+		return nil, err
+	}
+	switch cfg := inst.(type) {
+	case *vmess_inbound.Config:
+		return cfg.User, nil
+	case *vless_inbound.Config:
+		return cfg.Clients, nil
+	case *trojan.ServerConfig:
+		return cfg.Users, nil
+	case *shadowsocks.ServerConfig:
+		return cfg.Users, nil
+	case *shadowsocks_2022.MultiUserServerConfig:
+		return cfg.Users, nil
+	}
+	return nil, errors.New("inbound 协议不支持用户管理: " + cinb.Protocol)
+}
+
+// ----------------------------------------------------------------------------
+
+/**
+ * 添加用户, client 同 inbound 配置中 settings.clients 的元素
+ * level >= 0 时指定用户等级
+ */
+func (this *XrayServe) AddUser(tag string, client map[string]any, level int) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	idx := this.FindInboundTag(tag)
+	if idx < 0 {
+		return errors.New("inbound 未找到: " + tag)
+	}
+	email, _ := client["email"].(string)
+	if email == "" {
+		return errors.New("用户未指定 email")
+	}
+	if level >= 0 {
+		if this.Xconf.Policy == nil || this.Xconf.Policy.Levels[uint32(level)] == nil {
+			fmt.Printf("policy 等级未定义, 使用默认策略: %d\n", level)
+		}
+		client["level"] = level
+	}
+	cinb := this.Xconf.InboundConfigs[idx]
+	clients, err := InboundClients(&cinb)
+	if err != nil {
+		return err
+	}
+	for _, cln := range clients {
+		if cln["email"] == email {
+			return errors.New("用户已存在: " + email)
+		}
+	}
+	// 构建用户
+	ucnf, err := InboundWithClients(cinb, []map[string]any{client})
+	if err != nil {
+		return err
+	}
+	users, err := InboundMemoryUsers(ucnf)
+	if err != nil {
+		fmt.Printf("user 转换配置文件失败: %s\n", err.Error())
+		return err
+	}
+	if len(users) != 1 {
+		return errors.New("无效的用户: " + email)
+	}
+	// 添加用户
+	ctx := context.TODO()
+	mng := this.XrayA.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	hdl, err := mng.GetHandler(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if err := (&command.AddUserOperation{User: users[0]}).ApplyInbound(ctx, hdl); err != nil {
+		fmt.Printf("添加user 失败: %s\n", err.Error())
+		return err
+	}
+	// 保存配置
+	if cinb, err = InboundWithClients(cinb, append(clients, client)); err != nil {
+		return err
+	}
+	this.Xconf.InboundConfigs[idx] = cinb
+	return nil
+}
+
+/**
+ * 删除用户
+ */
+func (this *XrayServe) DelUser(tag string, email string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	ctx := context.TODO()
+	mng := this.XrayA.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	hdl, err := mng.GetHandler(ctx, tag)
+	if err != nil {
+		return err
+	}
+	if err := (&command.RemoveUserOperation{Email: email}).ApplyInbound(ctx, hdl); err != nil {
+		fmt.Printf("删除user 失败: %s\n", err.Error())
+		return err
+	}
+	// 保存配置
+	if idx := this.FindInboundTag(tag); idx >= 0 {
+		cinb := this.Xconf.InboundConfigs[idx]
+		clients, err := InboundClients(&cinb)
+		if err != nil {
+			return err
+		}
+		list := []map[string]any{}
+		for _, cln := range clients {
+			if cln["email"] != email {
+				list = append(list, cln)
+			}
+		}
+		if cinb, err = InboundWithClients(cinb, list); err != nil {
+			return err
+		}
+		this.Xconf.InboundConfigs[idx] = cinb
+	}
+	return nil
+}
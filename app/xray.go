@@ -10,6 +10,7 @@ import (
 	"os"
 	"reflect"
 	"strings"
+	"sync"
 	"time"
 	"unsafe"
 
@@ -29,15 +30,20 @@ var xray_conf string
 
 type XrayServe struct {
 	Print bool   // 是否打印配置文件
+	Plain bool   // 打印配置文件时不脱敏
 	Reset bool   // 是否重置配置文件
 	Xrayc string // 配置文件
 
 	Xconf *conf.Config   // 配置
 	XrayA *core.Instance // 实例
+	Certs *CertStore     // 证书库, 解析 inbound 中的证书引用
+	Crypt *ConfCipher    // 配置文件加密, nil 不加密
 
 	Start *time.Time // 启动时间
 	Stopt *time.Time // 停止时间
 	Exist error      // 错误
+
+	lock sync.RWMutex // 保护实例切换和内存配置, 导出的方法加锁, 小写的方法由调用方加锁
 }
 
 // ----------------------------------------------------------------------------
@@ -58,25 +64,63 @@ func (this *XrayServe) FileExists(path string) bool {
  * 判断Xray是否运行中
  */
 func (this *XrayServe) IsRunning() bool {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.isRunning()
+}
+
+func (this *XrayServe) isRunning() bool {
 	return this.XrayA != nil && this.XrayA.IsRunning()
 }
 
+/**
+ * 当前Xray实例, 重启后会替换
+ */
+func (this *XrayServe) Instance() *core.Instance {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.XrayA
+}
+
+/**
+ * 启动时间, 未启动返回 nil
+ */
+func (this *XrayServe) Started() *time.Time {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.Start
+}
+
 // ----------------------------------------------------------------------------
 
 /**
- * 保存Xray配置
+ * 保存Xray配置, 配置了密钥时加密, 先写入临时文件再替换, 权限 0600
+ * 只写入运行配置文件(Xrayc), 不修改原始配置文件
  */
 func (this *XrayServe) SaveXray() string {
 	if this.Xrayc == "" {
 		return "Xray配置文件为空"
 	}
+	this.lock.RLock()
 	bts, err := json.MarshalIndent(this.Xconf, "", "  ")
+	this.lock.RUnlock()
 	if err != nil {
 		msg := fmt.Sprintf("保存Xray配置失败: %s", err.Error())
 		fmt.Println(msg)
 		return msg
 	}
-	if err := os.WriteFile(this.Xrayc, bts, 0644); err != nil {
+	if bts, err = this.Crypt.Seal(bts); err != nil {
+		msg := fmt.Sprintf("加密Xray配置失败: %s", err.Error())
+		fmt.Println(msg)
+		return msg
+	}
+	if err := writeSync(this.Xrayc+".tmp", bts, 0600); err != nil {
+		os.Remove(this.Xrayc + ".tmp")
+		msg := fmt.Sprintf("保存Xray配置失败: %s", err.Error())
+		fmt.Println(msg)
+		return msg
+	}
+	if err := os.Rename(this.Xrayc+".tmp", this.Xrayc); err != nil {
 		msg := fmt.Sprintf("保存Xray配置失败: %s", err.Error())
 		fmt.Println(msg)
 		return msg
@@ -89,7 +133,13 @@ func (this *XrayServe) SaveXray() string {
  * 停止Xray
  */
 func (this *XrayServe) StopXray() string {
-	if !this.IsRunning() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.stopXray()
+}
+
+func (this *XrayServe) stopXray() string {
+	if !this.isRunning() {
 		return "Xray未启动"
 	}
 	stop := time.Now()
@@ -109,14 +159,16 @@ func (this *XrayServe) StopXray() string {
  * 重启Xray
  */
 func (this *XrayServe) RestartXray(reload bool) string {
-	errmsg := this.StopXray()
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	errmsg := this.stopXray()
 	if errmsg != "" {
 		return errmsg
 	}
 	if reload {
 		this.XrayA = nil
 	}
-	return this.StartXray()
+	return this.startXray()
 }
 
 // ----------------------------------------------------------------------------
@@ -125,7 +177,13 @@ func (this *XrayServe) RestartXray(reload bool) string {
  * 启动Xray
  */
 func (this *XrayServe) StartXray() string {
-	if this.IsRunning() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.startXray()
+}
+
+func (this *XrayServe) startXray() string {
+	if this.isRunning() {
 		return "Xray已启动"
 	}
 	// 判断是新建 or 重启
@@ -188,7 +246,7 @@ func (this *XrayServe) ParseConf() (string, error) {
 		}
 		if this.Print {
 			fmt.Printf("==========================================\n")
-			fmt.Printf("配置文件: [内置], 配置内容: %s\n", xray_conf)
+			fmt.Printf("配置文件: [内置], 配置内容: %s\n", this.PrintConf([]byte(xray_conf)))
 			fmt.Printf("==========================================\n")
 		}
 		// this.Xconf = &conf.Config{
@@ -204,12 +262,15 @@ func (this *XrayServe) ParseConf() (string, error) {
 		if !this.FileExists(cfile) {
 			fmt.Println("默认配置文件不存在, 使用内置配置, 生成中...")
 			bts = []byte(xray_conf)
-			os.WriteFile(cfile, bts, 0644)
+			os.WriteFile(cfile, bts, 0644) // 原始配置文件不加密, 可以直接编辑
 		} else {
 			bts, err = os.ReadFile(cfile)
 			if err != nil {
 				return cfile, errors.New(fmt.Sprintf("读取配置文件失败: %s", err.Error()))
 			}
+			if bts, err = this.Crypt.Open(bts); err != nil {
+				return cfile, errors.New(fmt.Sprintf("解密配置文件失败: %s", err.Error()))
+			}
 		}
 		// xcc, err := core.LoadConfig("json", bytes.NewReader(bts))
 		// xcc, err := conf_serial.LoadJSONConfig(bytes.NewReader(bts))
@@ -219,20 +280,46 @@ func (this *XrayServe) ParseConf() (string, error) {
 		}
 		if this.Print {
 			fmt.Printf("==========================================\n")
-			fmt.Printf("配置文件: %s, 配置内容: %s\n", cfile, string(bts))
+			fmt.Printf("配置文件: %s, 配置内容: %s\n", cfile, this.PrintConf(bts))
 			fmt.Printf("==========================================\n")
 		}
 		this.Xconf = xcc
 	}
+	if this.Certs != nil {
+		for idx := range this.Xconf.InboundConfigs {
+			if _, err := this.Certs.Resolve(&this.Xconf.InboundConfigs[idx]); err != nil {
+				return cfile, errors.New(fmt.Sprintf("解析证书失败: %s", err.Error()))
+			}
+		}
+	}
 
 	return cfile, err
 }
 
+/**
+ * 打印的配置内容, 默认脱敏
+ */
+func (this *XrayServe) PrintConf(bts []byte) string {
+	if this.Plain {
+		return string(bts)
+	}
+	if text, ok := RedactJSON(bts); ok {
+		return text
+	}
+	return "[无法解析, 不打印]"
+}
+
 // ----------------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 
 func (this *XrayServe) AddInbound0(cinb *core.InboundHandlerConfig) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.addInbound0(cinb)
+}
+
+func (this *XrayServe) addInbound0(cinb *core.InboundHandlerConfig) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -240,6 +327,12 @@ func (this *XrayServe) AddInbound0(cinb *core.InboundHandlerConfig) error {
 }
 
 func (this *XrayServe) DelInbound0(tag string) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.delInbound0(tag)
+}
+
+func (this *XrayServe) delInbound0(tag string) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -249,6 +342,12 @@ func (this *XrayServe) DelInbound0(tag string) error {
 }
 
 func (this *XrayServe) LstInbound0() ([]any, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.lstInbound0()
+}
+
+func (this *XrayServe) lstInbound0() ([]any, error) {
 	data := []any{}
 	if this.Xconf == nil {
 		return data, errors.New("未初始化配置文件")
@@ -262,6 +361,12 @@ func (this *XrayServe) LstInbound0() ([]any, error) {
 }
 
 func (this *XrayServe) AddOutbound0(cotb *core.OutboundHandlerConfig) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.addOutbound0(cotb)
+}
+
+func (this *XrayServe) addOutbound0(cotb *core.OutboundHandlerConfig) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -269,6 +374,12 @@ func (this *XrayServe) AddOutbound0(cotb *core.OutboundHandlerConfig) error {
 }
 
 func (this *XrayServe) DelOutbound0(tag string) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.delOutbound0(tag)
+}
+
+func (this *XrayServe) delOutbound0(tag string) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -278,6 +389,12 @@ func (this *XrayServe) DelOutbound0(tag string) error {
 }
 
 func (this *XrayServe) LstOutbound0() ([]any, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.lstOutbound0()
+}
+
+func (this *XrayServe) lstOutbound0() ([]any, error) {
 	data := []any{}
 	if this.Xconf == nil {
 		return data, errors.New("未初始化配置文件")
@@ -291,6 +408,12 @@ func (this *XrayServe) LstOutbound0() ([]any, error) {
 }
 
 func (this *XrayServe) AddRoute0(rule *serial.TypedMessage) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.addRoute0(rule)
+}
+
+func (this *XrayServe) addRoute0(rule *serial.TypedMessage) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -300,6 +423,12 @@ func (this *XrayServe) AddRoute0(rule *serial.TypedMessage) error {
 }
 
 func (this *XrayServe) DelRoute0(tag string) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.delRoute0(tag)
+}
+
+func (this *XrayServe) delRoute0(tag string) error {
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -309,11 +438,20 @@ func (this *XrayServe) DelRoute0(tag string) error {
 }
 
 func (this *XrayServe) LstRoute0() ([]any, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.lstRoute0()
+}
+
+func (this *XrayServe) lstRoute0() ([]any, error) {
 	data := []any{}
 	if this.Xconf == nil {
 		return data, errors.New("未初始化配置文件")
 	}
-	rtr := this.XrayA.GetFeature(routing.RouterType()).(*router.Router)
+	rtr, ok := this.XrayA.GetFeature(routing.RouterType()).(*router.Router)
+	if !ok {
+		return data, nil // 未配置路由
+	}
 	val := reflect.ValueOf(rtr) // *router.Router routing.Router
 	// rules := val.Elem().FieldByName("rules").Interface().([]*router.Rule)
 	rule_ := unsafe.Pointer(val.Elem().FieldByName("rules").UnsafeAddr())
@@ -324,11 +462,48 @@ func (this *XrayServe) LstRoute0() ([]any, error) {
 	return data, nil
 }
 
+/**
+ * 将路由移动到首位, 路由只支持追加
+ */
+func (this *XrayServe) TopRoute0(tag string) error {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.topRoute0(tag)
+}
+
+func (this *XrayServe) topRoute0(tag string) error {
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	rtr, ok := this.XrayA.GetFeature(routing.RouterType()).(*router.Router)
+	if !ok {
+		return errors.New("未配置路由")
+	}
+	val := reflect.ValueOf(rtr)
+	lock := (*sync.Mutex)(unsafe.Pointer(val.Elem().FieldByName("mu").UnsafeAddr()))
+	lock.Lock()
+	defer lock.Unlock()
+	rule_ := (*[]*router.Rule)(unsafe.Pointer(val.Elem().FieldByName("rules").UnsafeAddr()))
+	rules := *rule_
+	for idx, rule := range rules {
+		if rule.RuleTag == tag {
+			list := make([]*router.Rule, 0, len(rules))
+			list = append(list, rule)
+			list = append(list, rules[:idx]...)
+			*rule_ = append(list, rules[idx+1:]...)
+			return nil
+		}
+	}
+	return errors.New("routing 未找到: " + tag)
+}
+
 // ----------------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 // ----------------------------------------------------------------------------
 
 func (this *XrayServe) AddInbound(cinb conf.InboundDetourConfig, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -339,6 +514,13 @@ func (this *XrayServe) AddInbound(cinb conf.InboundDetourConfig, sync bool) erro
 			return errors.New("inbound 已存在: " + tag)
 		}
 	}
+	certs := false
+	if this.Certs != nil {
+		var err error
+		if certs, err = this.Certs.Resolve(&cinb); err != nil {
+			return err
+		}
+	}
 	// 转换配置, 增加配置
 	if cinc, err := cinb.Build(); err != nil {
 		fmt.Println(fmt.Sprintf("inbound 转换配置文件失败: %s", err.Error()))
@@ -350,11 +532,16 @@ func (this *XrayServe) AddInbound(cinb conf.InboundDetourConfig, sync bool) erro
 	if sync {
 		// 保存配置
 		this.Xconf.InboundConfigs = append(this.Xconf.InboundConfigs, cinb)
+	} else if certs && this.FindInboundTag(cinb.Tag) < 0 {
+		// 未保存的 inbound, 记录以便替换证书
+		this.Certs.Track(cinb.Tag, &cinb)
 	}
 	return nil
 }
 
 func (this *XrayServe) DelInbound(tag string, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -365,7 +552,7 @@ func (this *XrayServe) DelInbound(tag string, sync bool) error {
 			return errors.New("inbound 未找到: " + tag)
 		}
 	}
-	if err := this.DelInbound0(tag); err != nil {
+	if err := this.delInbound0(tag); err != nil {
 		fmt.Println(fmt.Sprintf("删除inbound 失败: %s", err.Error()))
 		return err
 	}
@@ -373,9 +560,116 @@ func (this *XrayServe) DelInbound(tag string, sync bool) error {
 		cons := this.Xconf.InboundConfigs
 		this.Xconf.InboundConfigs = append(cons[:idx], cons[idx+1:]...)
 	}
+	if this.Certs != nil {
+		this.Certs.Track(tag, nil)
+	}
 	return nil
 }
 
+/**
+ * 重建 inbound, 用于重新加载证书等
+ * 先创建全部新的处理器, 任一配置或证书无效时不修改运行中的 inbound
+ * 全部创建成功后调用 commit(如写入证书文件), 再逐个替换, 替换失败时恢复全部已替换的 inbound
+ */
+func (this *XrayServe) RebuildInbounds(tags []string, commit func() error) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.Xconf == nil {
+		return errors.New("未初始化配置文件")
+	}
+	ctx := context.TODO()
+	mng := this.XrayA.GetFeature(inbound.ManagerType()).(inbound.Manager)
+	hdls, olds := []inbound.Handler{}, []inbound.Handler{}
+	for _, tag := range tags {
+		hdl, err := this.buildInbound(tag)
+		if err != nil {
+			return errors.New(tag + ": " + err.Error())
+		}
+		old, err := mng.GetHandler(ctx, tag)
+		if err != nil {
+			return errors.New("inbound 未运行: " + tag)
+		}
+		hdls, olds = append(hdls, hdl), append(olds, old)
+	}
+	if commit != nil {
+		if err := commit(); err != nil {
+			return err
+		}
+	}
+	// 恢复前 num 个 inbound, 删除新的处理器后添加原处理器
+	restore := func(num int) {
+		for idx := 0; idx < num; idx++ {
+			mng.RemoveHandler(ctx, tags[idx])
+			if err := mng.AddHandler(ctx, olds[idx]); err != nil {
+				fmt.Println(fmt.Sprintf("恢复inbound 失败: %s, %s", tags[idx], err.Error()))
+			}
+		}
+	}
+	for idx, hdl := range hdls {
+		if err := mng.RemoveHandler(ctx, tags[idx]); err != nil {
+			fmt.Println(fmt.Sprintf("删除inbound 失败: %s", err.Error()))
+			restore(idx)
+			return errors.New(tags[idx] + ": " + err.Error())
+		}
+		if err := mng.AddHandler(ctx, hdl); err != nil {
+			fmt.Println(fmt.Sprintf("启动inbound 失败, 恢复原inbound: %s", err.Error()))
+			restore(idx + 1)
+			return errors.New(tags[idx] + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+/**
+ * 根据配置创建 inbound 处理器, 不添加到运行实例
+ */
+func (this *XrayServe) buildInbound(tag string) (inbound.Handler, error) {
+	cinb, ok := conf.InboundDetourConfig{}, false
+	if idx := this.FindInboundTag(tag); idx >= 0 {
+		cinb, ok = this.Xconf.InboundConfigs[idx], true
+	} else if this.Certs != nil {
+		cinb, ok = this.Certs.Tracked(tag)
+	}
+	if !ok {
+		return nil, errors.New("inbound 未找到: " + tag)
+	}
+	if this.Certs != nil {
+		if _, err := this.Certs.Resolve(&cinb); err != nil {
+			return nil, err
+		}
+	}
+	cinc, err := cinb.Build()
+	if err != nil {
+		fmt.Println(fmt.Sprintf("inbound 转换配置文件失败: %s", err.Error()))
+		return nil, err
+	}
+	raw, err := core.CreateObject(this.XrayA, cinc)
+	if err != nil {
+		fmt.Println(fmt.Sprintf("创建inbound 失败: %s", err.Error()))
+		return nil, err
+	}
+	hdl, ok := raw.(inbound.Handler)
+	if !ok {
+		return nil, errors.New("inbound 类型错误: " + tag)
+	}
+	return hdl, nil
+}
+
+/**
+ * 复制配置中的 inbound, 用于在锁外遍历
+ */
+func (this *XrayServe) CopyInbounds() []conf.InboundDetourConfig {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if this.Xconf == nil {
+		return []conf.InboundDetourConfig{}
+	}
+	return append([]conf.InboundDetourConfig{}, this.Xconf.InboundConfigs...)
+}
+
+/**
+ * 查找配置中的 inbound, Find* 读取内存配置, 调用方加锁
+ */
 func (this *XrayServe) FindInboundTag(tag string) int {
 	found := -1
 	for idx, ib := range this.Xconf.InboundConfigs {
@@ -390,6 +684,8 @@ func (this *XrayServe) FindInboundTag(tag string) int {
 // ----------------------------------------------------------------------------
 
 func (this *XrayServe) AddOutbound(cotb conf.OutboundDetourConfig, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -416,6 +712,8 @@ func (this *XrayServe) AddOutbound(cotb conf.OutboundDetourConfig, sync bool) er
 }
 
 func (this *XrayServe) DelOutbound(tag string, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -427,7 +725,7 @@ func (this *XrayServe) DelOutbound(tag string, sync bool) error {
 		}
 	}
 
-	if err := this.DelOutbound0(tag); err != nil {
+	if err := this.delOutbound0(tag); err != nil {
 		fmt.Println(fmt.Sprintf("删除outbound 失败: %s", err.Error()))
 		return err
 	}
@@ -453,6 +751,8 @@ func (this *XrayServe) FindOutboundTag(tag string) int {
 // ----------------------------------------------------------------------------
 
 func (this *XrayServe) AddRoute(rule json.RawMessage, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -474,18 +774,23 @@ func (this *XrayServe) AddRoute(rule json.RawMessage, sync bool) error {
 	}
 
 	rul := &router.Config{Rule: []*router.RoutingRule{rule_}}
-	if err := this.AddRoute0(serial.ToTypedMessage(rul)); err != nil {
+	if err := this.addRoute0(serial.ToTypedMessage(rul)); err != nil {
 		fmt.Println(fmt.Sprintf("routing 添加路由失败: %s", err.Error()))
 		return err
 	}
 
 	if sync {
+		if this.Xconf.RouterConfig == nil {
+			this.Xconf.RouterConfig = &conf.RouterConfig{}
+		}
 		this.Xconf.RouterConfig.RuleList = append(this.Xconf.RouterConfig.RuleList, rule)
 	}
 	return nil
 }
 
 func (this *XrayServe) DelRoute(tag string, sync bool) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	if this.Xconf == nil {
 		return errors.New("未初始化配置文件")
 	}
@@ -497,7 +802,7 @@ func (this *XrayServe) DelRoute(tag string, sync bool) error {
 		}
 	}
 
-	if err := this.DelRoute0(tag); err != nil {
+	if err := this.delRoute0(tag); err != nil {
 		fmt.Println(fmt.Sprintf("routing 删除路由失败: %s", err.Error()))
 		return err
 	}
@@ -511,6 +816,9 @@ func (this *XrayServe) DelRoute(tag string, sync bool) error {
 
 func (this *XrayServe) FindRoutingTag(tag string) int {
 	found := -1
+	if this.Xconf.RouterConfig == nil || tag == "" {
+		return found
+	}
 	for idx, ob := range this.Xconf.RouterConfig.RuleList {
 		rule := conf.RouterRule{}
 		if err := json.Unmarshal(ob, &rule); err != nil {
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+func TestConfCoreSplit(t *testing.T) {
+	serve := testXray(t)
+	cotb := conf.OutboundDetourConfig{Protocol: "blackhole", Tag: "blk"}
+	if err := serve.AddOutbound(cotb, false); err != nil {
+		t.Fatal(err)
+	}
+	if serve.FindOutboundTag("blk") >= 0 {
+		t.Fatal("core outbound synced to config")
+	}
+	// 只在运行实例中的出站, conf 删除失败
+	if err := serve.DelOutbound("blk", true); err == nil {
+		t.Fatal("conf delete of runtime-only outbound succeeded")
+	}
+	if err := serve.DelOutbound("blk", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := serve.AddOutbound(cotb, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.AddRoute(json.RawMessage(`{"ruleTag": "r", "outboundTag": "blk", "network": "udp"}`), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.AddRoute(json.RawMessage(`{"outboundTag": "blk", "network": "tcp"}`), true); err == nil {
+		t.Fatal("conf rule without ruleTag accepted")
+	}
+	// core 删除不修改配置
+	if err := serve.DelRoute("r", false); err != nil {
+		t.Fatal(err)
+	}
+	if serve.FindRoutingTag("r") < 0 {
+		t.Fatal("core delete removed rule from config")
+	}
+	if err := serve.DelOutbound("blk", true); err != nil {
+		t.Fatal(err)
+	}
+	if serve.FindOutboundTag("blk") >= 0 {
+		t.Fatal("conf delete kept outbound in config")
+	}
+}
+
+func TestLstInboundOnline(t *testing.T) {
+	serve := testXray(t)
+	cinb := conf.InboundDetourConfig{}
+	text := fmt.Sprintf(`{"tag": "in", "listen": "127.0.0.1", "port": %d, "protocol": "vless",
+		"settings": {"clients": [{"id": %q, "email": "a@in"}], "decryption": "none"}}`, freePort(t), testUuid)
+	if err := json.Unmarshal([]byte(text), &cinb); err != nil {
+		t.Fatal(err)
+	}
+	if err := serve.AddInbound(cinb, true); err != nil {
+		t.Fatal(err)
+	}
+	data, err := serve.LstInboundOnline()
+	if err != nil || len(data) != 1 || data[0] != "in" {
+		t.Fatalf("tags: %v %v", data, err)
+	}
+	if err := serve.SetPolicy(0, &conf.Policy{StatsUserOnline: true}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = serve.LstInboundOnline()
+	if err != nil || len(data) != 1 {
+		t.Fatalf("online: %v %v", data, err)
+	}
+	if item, ok := data[0].(*InboundOnline); !ok || item.Tag != "in" || item.Online != 0 {
+		t.Fatalf("online: %#v", data[0])
+	}
+	if got := (&Principal{Scopes: []string{"tag:in"}}).FilterTags(data); len(got) != 1 {
+		t.Fatalf("filter: %v", got)
+	}
+	if got := (&Principal{Scopes: []string{"tag:x"}}).FilterTags(data); len(got) != 0 {
+		t.Fatalf("filter: %v", got)
+	}
+}
@@ -5,7 +5,12 @@ go 1.25
 // github.com/xtls/xray-core
 replace github.com/xtls/xray-core => ../Xray-core
 
-require github.com/xtls/xray-core v0.0.0-00010101000000-000000000000
+require (
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	github.com/xtls/xray-core v0.0.0-00010101000000-000000000000
+	gopkg.in/yaml.v2 v2.4.0
+	lukechampine.com/blake3 v1.4.1
+)
 
 require (
 	github.com/andybalholm/brotli v1.0.6 // indirect
@@ -45,7 +50,5 @@ require (
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20251022142026-3a174f9686a8 // indirect
 	google.golang.org/grpc v1.77.0 // indirect
 	google.golang.org/protobuf v1.36.10 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gvisor.dev/gvisor v0.0.0-20250428193742-2d800c3129d5 // indirect
-	lukechampine.com/blake3 v1.4.1 // indirect
 )